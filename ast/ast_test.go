@@ -0,0 +1,214 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nicholasbailey/langkit"
+)
+
+func makeLanguage() langkit.LanguageSpecification {
+	spec := langkit.NewLanguage()
+	spec.DefineInfix("≠", 20)
+	spec.DefineInfix("+", 30)
+	spec.DefineInfix("*", 40)
+	spec.DefinePrefix("-", 50)
+	spec.DefineParens("(", ")")
+	spec.DefineCall("(", ")", ",")
+	spec.DefineQuotes('"', '"', langkit.StringLiteral)
+	return spec
+}
+
+func parse(t *testing.T, source string) *langkit.Token {
+	parser := langkit.NewParser(langkit.NewFileLexer(strings.NewReader(source), "test.ks", makeLanguage()))
+	tree, err := parser.Expression(0)
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	return tree
+}
+
+func shape(token *langkit.Token) string {
+	if token == nil {
+		return "<nil>"
+	}
+	if len(token.Children) == 0 {
+		return token.Value
+	}
+	parts := []string{token.Value}
+	for _, child := range token.Children {
+		parts = append(parts, shape(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestWalkOrderAndActions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		enter    func(cursor *Cursor) Action
+		leave    func(cursor *Cursor) Action
+		expected string
+		complete bool
+	}{
+		{"full walk", nil, nil, "<+ <a >a <* <b >b <c >c >* >+", true},
+		{"skip children", func(cursor *Cursor) Action {
+			if cursor.Node().Value == "*" {
+				return SkipChildren
+			}
+			return Continue
+		}, nil, "<+ <a >a <* >+", true},
+		{"stop on enter", func(cursor *Cursor) Action {
+			if cursor.Node().Value == "b" {
+				return Stop
+			}
+			return Continue
+		}, nil, "<+ <a >a <* <b", false},
+		{"stop on leave", nil, func(cursor *Cursor) Action {
+			if cursor.Node().Value == "*" {
+				return Stop
+			}
+			return Continue
+		}, "<+ <a >a <* <b >b <c >c >*", false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			events := []string{}
+			complete := Walk(parse(t, "a + b * c"), VisitorFuncs{
+				EnterFunc: func(cursor *Cursor) Action {
+					events = append(events, "<"+cursor.Node().Value)
+					if testCase.enter != nil {
+						return testCase.enter(cursor)
+					}
+					return Continue
+				},
+				LeaveFunc: func(cursor *Cursor) Action {
+					events = append(events, ">"+cursor.Node().Value)
+					if testCase.leave != nil {
+						return testCase.leave(cursor)
+					}
+					return Continue
+				},
+			})
+			if actual := strings.Join(events, " "); actual != testCase.expected || complete != testCase.complete {
+				t.Fatalf("Expected %q (%v), got %q (%v)", testCase.expected, testCase.complete, actual, complete)
+			}
+		})
+	}
+}
+
+func TestCursorTracksParentAndPath(t *testing.T) {
+	tree := parse(t, "f(a, -b)")
+	var found *Cursor
+	Walk(tree, VisitorFuncs{
+		EnterFunc: func(cursor *Cursor) Action {
+			if cursor.Node().Value == "b" {
+				found = &Cursor{
+					path:    append([]*langkit.Token{}, cursor.path...),
+					indices: append([]int{}, cursor.indices...),
+				}
+				if cursor.Parent().Value != "-" || cursor.Index() != 0 || cursor.Depth() != 2 {
+					t.Fatalf("Unexpected parent %v, index %v or depth %v", cursor.Parent().Value, cursor.Index(), cursor.Depth())
+				}
+			}
+			return Continue
+		},
+	})
+	if found == nil {
+		t.Fatalf("Expected to visit b")
+	}
+	path := []string{}
+	for _, ancestor := range found.Path() {
+		path = append(path, ancestor.Value)
+	}
+	if strings.Join(path, " ") != "( -" || found.Path()[0] != tree {
+		t.Fatalf("Unexpected path %v", path)
+	}
+	root := &Cursor{path: []*langkit.Token{tree}, indices: []int{-1}}
+	if root.Parent() != nil || root.Index() != -1 || len(root.Path()) != 0 {
+		t.Fatalf("Expected the root to have no parent or path")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	values := []string{}
+	Inspect(parse(t, "f(a + b, c)"), func(node *langkit.Token) bool {
+		values = append(values, node.Value)
+		return node.Value != "+"
+	})
+	if strings.Join(values, " ") != "( f + c" {
+		t.Fatalf("Unexpected nodes %v", values)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	foldZero := func(cursor *Cursor) *langkit.Token {
+		node := cursor.Node()
+		if node.Value == "+" && len(node.Children) == 2 {
+			if node.Children[1].Value == "0" {
+				return node.Children[0]
+			}
+			if node.Children[0].Value == "0" {
+				return node.Children[1]
+			}
+		}
+		return node
+	}
+	dropZeros := func(cursor *Cursor) *langkit.Token {
+		if cursor.Node().Value == "0" && cursor.Parent() != nil && cursor.Parent().Value == "(" {
+			return nil
+		}
+		return cursor.Node()
+	}
+	deleteAll := func(cursor *Cursor) *langkit.Token {
+		return nil
+	}
+	testCases := []struct {
+		source   string
+		rewriter Rewriter
+		expected string
+	}{
+		{"a * (b + 0)", foldZero, "(* a b)"},
+		{"0 + (0 + a) * b", foldZero, "(* a b)"},
+		{"a * b", foldZero, "(* a b)"},
+		{"f(0, a, 0, b)", dropZeros, "(( f a b)"},
+		{"a + b", deleteAll, "<nil>"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			tree := parse(t, testCase.source)
+			original := shape(tree)
+			rewritten := Rewrite(tree, testCase.rewriter)
+			if actual := shape(rewritten); actual != testCase.expected {
+				t.Fatalf("Expected %v, got %v", testCase.expected, actual)
+			}
+			if shape(tree) != original {
+				t.Fatalf("Expected the original tree %v to be unchanged, got %v", original, shape(tree))
+			}
+		})
+	}
+}
+
+func TestRewriteSharesUnchangedSubtrees(t *testing.T) {
+	tree := parse(t, "f(a * b, c + 0)")
+	rewritten := Rewrite(tree, func(cursor *Cursor) *langkit.Token {
+		if cursor.Node().Value == "+" {
+			return cursor.Node().Children[0]
+		}
+		return cursor.Node()
+	})
+	if rewritten == tree {
+		t.Fatalf("Expected the changed root to be copied")
+	}
+	if rewritten.Children[1] != tree.Children[1] {
+		t.Fatalf("Expected the unchanged argument to be shared")
+	}
+	if rewritten.Children[2] != tree.Children[2].Children[0] {
+		t.Fatalf("Expected the replacement to be used as is")
+	}
+	if rewritten.Arity != tree.Arity {
+		t.Fatalf("Expected the arity to be kept, got %v", rewritten.Arity)
+	}
+	if Rewrite(tree, func(cursor *Cursor) *langkit.Token { return cursor.Node() }) != tree {
+		t.Fatalf("Expected an identity rewrite to return the original tree")
+	}
+}
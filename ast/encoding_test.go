@@ -0,0 +1,152 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nicholasbailey/langkit"
+)
+
+func encodeSExpr(t *testing.T, tree *langkit.Token, options EncodeOptions) string {
+	var buffer bytes.Buffer
+	if err := EncodeSExpr(&buffer, tree, options); err != nil {
+		t.Fatalf("Unexpected encoding error %v", err)
+	}
+	return buffer.String()
+}
+
+func encodeJSON(t *testing.T, tree *langkit.Token, options EncodeOptions) string {
+	var buffer bytes.Buffer
+	if err := EncodeJSON(&buffer, tree, options); err != nil {
+		t.Fatalf("Unexpected encoding error %v", err)
+	}
+	return buffer.String()
+}
+
+// Describes the encoded fields of the tree
+func dump(token *langkit.Token) string {
+	if token == nil {
+		return "nil"
+	}
+	parts := []string{fmt.Sprintf("%q %q %v %v:%v %+v", token.Symbol, token.Value, token.Arity, token.Line, token.Col, token.Span)}
+	for _, child := range token.Children {
+		parts = append(parts, dump(child))
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+func TestEncodeSExpr(t *testing.T) {
+	testCases := []struct {
+		source   string
+		options  EncodeOptions
+		expected string
+	}{
+		{"a + 1", EncodeOptions{OmitPositions: true}, `(+ (NAME "a") (INT "1"))`},
+		{`f(-x, "a b")`, EncodeOptions{OmitPositions: true}, `(FUNCTIONINVOCATION "(" (NAME "f") (- (NAME "x")) (STRING "a b"))`},
+		{"a + 1", EncodeOptions{}, `(+ @1:3 ["test.ks" 1:1:0 1:6:5] (NAME "a" @1:1 ["test.ks" 1:1:0 1:2:1]) (INT "1" @1:5 ["test.ks" 1:5:4 1:6:5]))`},
+		{"a * (b + c)", EncodeOptions{OmitPositions: true, Indent: "  "}, "(*\n  (NAME \"a\")\n  (+\n    (NAME \"b\")\n    (NAME \"c\")))"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			actual := encodeSExpr(t, parse(t, testCase.source), testCase.options)
+			if actual != testCase.expected+"\n" {
+				t.Fatalf("Expected %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	actual := encodeJSON(t, parse(t, "-x"), EncodeOptions{OmitPositions: true})
+	expected := `{"symbol":"-","value":"-","children":[{"symbol":"(NAME)","value":"x"}]}` + "\n"
+	if actual != expected {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+// Builds trees the lexer cannot, with symbols that need quoting,
+// missing children and arities that differ from the child count
+func unusualTree() *langkit.Token {
+	return &langkit.Token{Symbol: "INT", Value: "", Arity: 3, Children: []*langkit.Token{
+		{Symbol: "_", Value: "_"},
+		nil,
+		{Symbol: "$x", Value: "a b", Line: 2, Col: 7, Children: []*langkit.Token{
+			{Symbol: "(NAME)", Value: "(NAME)"},
+			{Symbol: "with space", Value: "\té"},
+			{Symbol: "...", Value: "..."},
+		}},
+	}}
+}
+
+func TestEncodingsRoundTrip(t *testing.T) {
+	trees := map[string]*langkit.Token{
+		"parsed":  parse(t, `f(a + -b, "x\ny") * (c + 1.5)`),
+		"unusual": unusualTree(),
+		"non-ASCII": {Symbol: "≠", Value: "≠", Children: []*langkit.Token{
+			{Symbol: "Å", Value: "Å"},
+			{Symbol: "(STRING)", Value: "à≠\u00a0"},
+		}},
+	}
+	for name, tree := range trees {
+		for _, options := range []EncodeOptions{{}, {Indent: "\t"}} {
+			t.Run(fmt.Sprintf("%v %q", name, options.Indent), func(t *testing.T) {
+				expected := dump(tree)
+				fromSExpr, err := DecodeSExpr(strings.NewReader(encodeSExpr(t, tree, options)))
+				if err != nil {
+					t.Fatalf("Unexpected S-expression decoding error %v", err)
+				}
+				if actual := dump(fromSExpr); actual != expected {
+					t.Fatalf("Expected %v, got %v from S-expression", expected, actual)
+				}
+				fromJSON, err := DecodeJSON(strings.NewReader(encodeJSON(t, tree, options)))
+				if err != nil {
+					t.Fatalf("Unexpected JSON decoding error %v", err)
+				}
+				if actual := dump(fromJSON); actual != expected {
+					t.Fatalf("Expected %v, got %v from JSON", expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		decode  func(input string) (*langkit.Token, error)
+		input   string
+		message string
+	}{
+		{decodeSExprString, "", "expected a node"},
+		{decodeSExprString, "()", "expected a node"},
+		{decodeSExprString, "(+ (NAME \"a\"", "expected a child"},
+		{decodeSExprString, "(+ a)", "expected a child"},
+		{decodeSExprString, "(STRING \"abc)", "unterminated string"},
+		{decodeSExprString, "(NAME \"a\" @x)", "invalid position"},
+		{decodeSExprString, "(NAME \"a\" [1:1:0])", "invalid span position"},
+		{decodeSExprString, "(NAME \"a\" #x)", "invalid arity"},
+		{decodeSExprString, "(NAME \"a\") (NAME \"b\")", "unexpected text"},
+		{decodeSExprString, "(\xe2\x89)", "invalid UTF-8"},
+		{decodeJSONString, "null", "expected a node"},
+		{decodeJSONString, `{"symbol": "+", "kids": []}`, "unknown field"},
+		{decodeJSONString, `{"value": "a"}`, "has no symbol"},
+		{decodeJSONString, `{"symbol": "+"`, "unexpected EOF"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			_, err := testCase.decode(testCase.input)
+			if err == nil || !strings.Contains(err.Error(), testCase.message) || !strings.HasPrefix(err.Error(), "decodeerror: ") {
+				t.Fatalf("Expected an error containing %q, got %v", testCase.message, err)
+			}
+		})
+	}
+}
+
+func decodeSExprString(input string) (*langkit.Token, error) {
+	return DecodeSExpr(strings.NewReader(input))
+}
+
+func decodeJSONString(input string) (*langkit.Token, error) {
+	return DecodeJSON(strings.NewReader(input))
+}
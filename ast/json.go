@@ -0,0 +1,128 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nicholasbailey/langkit"
+)
+
+// Controls how trees are encoded
+type EncodeOptions struct {
+	// Leaves out lines, columns and spans, as golden tests often want
+	OmitPositions bool
+	// Puts each node on its own line, indented by this string per
+	// level, instead of encoding the tree on a single line
+	Indent string
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Col    int `json:"col"`
+	Offset int `json:"offset"`
+}
+
+type jsonSpan struct {
+	File  string       `json:"file,omitempty"`
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonNode struct {
+	Symbol langkit.Symbol `json:"symbol"`
+	Value  string         `json:"value"`
+	// Left out when it is the number of children
+	Arity    *int        `json:"arity,omitempty"`
+	Line     int         `json:"line,omitempty"`
+	Col      int         `json:"col,omitempty"`
+	Span     *jsonSpan   `json:"span,omitempty"`
+	Children []*jsonNode `json:"children,omitempty"`
+}
+
+// Writes the tree as JSON. Each node is an object holding its symbol,
+// value, arity, position, span and children; parsing functions,
+// binding powers and trivia are not encoded.
+func EncodeJSON(writer io.Writer, tree *langkit.Token, options EncodeOptions) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	if options.Indent != "" {
+		encoder.SetIndent("", options.Indent)
+	}
+	return encoder.Encode(toJSON(tree, options))
+}
+
+func toJSON(token *langkit.Token, options EncodeOptions) *jsonNode {
+	if token == nil {
+		return nil
+	}
+	node := &jsonNode{Symbol: token.Symbol, Value: token.Value}
+	if token.Arity != len(token.Children) {
+		arity := token.Arity
+		node.Arity = &arity
+	}
+	if !options.OmitPositions {
+		node.Line = token.Line
+		node.Col = token.Col
+		if token.Span.IsValid() {
+			node.Span = &jsonSpan{
+				File:  token.Span.File,
+				Start: jsonPosition(token.Span.Start),
+				End:   jsonPosition(token.Span.End),
+			}
+		}
+	}
+	for _, child := range token.Children {
+		node.Children = append(node.Children, toJSON(child, options))
+	}
+	return node
+}
+
+// Reads a tree written by EncodeJSON. The tokens have no parsing
+// functions or binding powers.
+func DecodeJSON(reader io.Reader) (*langkit.Token, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var node *jsonNode
+	if err := decoder.Decode(&node); err != nil {
+		return nil, fmt.Errorf("decodeerror: %v", err)
+	}
+	if node == nil {
+		return nil, fmt.Errorf("decodeerror: expected a node, got null")
+	}
+	return fromJSON(node)
+}
+
+func fromJSON(node *jsonNode) (*langkit.Token, error) {
+	if node == nil {
+		return nil, nil
+	}
+	if node.Symbol == "" {
+		return nil, fmt.Errorf("decodeerror: node %q has no symbol", node.Value)
+	}
+	token := &langkit.Token{
+		Symbol: node.Symbol,
+		Value:  node.Value,
+		Arity:  len(node.Children),
+		Line:   node.Line,
+		Col:    node.Col,
+	}
+	if node.Arity != nil {
+		token.Arity = *node.Arity
+	}
+	if node.Span != nil {
+		token.Span = langkit.Span{
+			File:  node.Span.File,
+			Start: langkit.Position(node.Span.Start),
+			End:   langkit.Position(node.Span.End),
+		}
+	}
+	for _, child := range node.Children {
+		childToken, err := fromJSON(child)
+		if err != nil {
+			return nil, err
+		}
+		token.Children = append(token.Children, childToken)
+	}
+	return token, nil
+}
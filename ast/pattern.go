@@ -0,0 +1,444 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nicholasbailey/langkit"
+)
+
+// Tests the nodes matched by a wildcard or capture of a pattern
+type Predicate func(node *langkit.Token) bool
+
+type patternKind int
+
+const (
+	// (symbol "value" children...)
+	nodePattern patternKind = iota
+	// _ or $name, matching any one node
+	anyPattern
+	// ... or $name..., matching any number of children
+	sequencePattern
+	// (), matching a missing child
+	nilPattern
+)
+
+type patternNode struct {
+	kind      patternKind
+	symbol    langkit.Symbol
+	anySymbol bool
+	value     string
+	hasValue  bool
+	name      string
+	predicate Predicate
+	children  []*patternNode
+}
+
+// A tree pattern, written like the S-expressions of EncodeSExpr:
+//
+//	(+ $x (INT "0"))
+//
+// A node pattern matches nodes with its symbol, or any symbol if it
+// is _, with its value if one is given, and with children matching
+// its children. Among the children, _ matches any node, ... matches
+// any number of nodes, $name and $name... capture what they match, a
+// name used twice must match equal trees both times, and a :name
+// suffix, as in $x:constant or ...:constant, keeps only the nodes
+// accepted by the predicate of that name.
+type Pattern struct {
+	root     *patternNode
+	captures map[string]patternKind
+}
+
+// A node matched by a pattern, with the nodes and sequences of nodes
+// it captured
+type Match struct {
+	Node      *langkit.Token
+	Span      langkit.Span
+	Captures  map[string]*langkit.Token
+	Sequences map[string][]*langkit.Token
+}
+
+// Compiles a pattern whose predicates are looked up in the map, which
+// may be nil if the pattern uses none
+func CompilePattern(source string, predicates map[string]Predicate) (*Pattern, error) {
+	parser := newPatternParser(source, predicates, false)
+	root, err := parser.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{root: root, captures: parser.captures}, nil
+}
+
+// Compiles a pattern, panicking if it is invalid
+func MustCompilePattern(source string, predicates map[string]Predicate) *Pattern {
+	pattern, err := CompilePattern(source, predicates)
+	if err != nil {
+		panic(err)
+	}
+	return pattern
+}
+
+// Matches the pattern against the node itself
+func (pattern *Pattern) Match(node *langkit.Token) (Match, bool) {
+	captured := &bindings{nodes: map[string]*langkit.Token{}, sequences: map[string][]*langkit.Token{}}
+	if node == nil || !captured.match(pattern.root, node) {
+		return Match{}, false
+	}
+	return Match{Node: node, Span: node.Span, Captures: captured.nodes, Sequences: captured.sequences}, true
+}
+
+// Returns the matches of the pattern against every node of the tree,
+// in depth first order
+func (pattern *Pattern) Search(tree *langkit.Token) []Match {
+	matches := []Match{}
+	Inspect(tree, func(node *langkit.Token) bool {
+		if match, ok := pattern.Match(node); ok {
+			matches = append(matches, match)
+		}
+		return true
+	})
+	return matches
+}
+
+type bindings struct {
+	nodes     map[string]*langkit.Token
+	sequences map[string][]*langkit.Token
+}
+
+func (captured *bindings) clone() *bindings {
+	copied := &bindings{nodes: map[string]*langkit.Token{}, sequences: map[string][]*langkit.Token{}}
+	for name, node := range captured.nodes {
+		copied.nodes[name] = node
+	}
+	for name, nodes := range captured.sequences {
+		copied.sequences[name] = nodes
+	}
+	return copied
+}
+
+// Matches a pattern that is not a sequence, possibly leaving partial
+// captures behind when it fails
+func (captured *bindings) match(pattern *patternNode, node *langkit.Token) bool {
+	switch pattern.kind {
+	case nilPattern:
+		return node == nil
+	case anyPattern:
+		if node == nil || (pattern.predicate != nil && !pattern.predicate(node)) {
+			return false
+		}
+		if pattern.name == "" {
+			return true
+		}
+		if bound, ok := captured.nodes[pattern.name]; ok {
+			return equalTrees(bound, node)
+		}
+		captured.nodes[pattern.name] = node
+		return true
+	case nodePattern:
+		if node == nil || (!pattern.anySymbol && node.Symbol != pattern.symbol) || (pattern.hasValue && node.Value != pattern.value) {
+			return false
+		}
+		return captured.matchChildren(pattern.children, node.Children)
+	}
+	return false
+}
+
+func (captured *bindings) matchChildren(patterns []*patternNode, nodes []*langkit.Token) bool {
+	if len(patterns) == 0 {
+		return len(nodes) == 0
+	}
+	first := patterns[0]
+	if first.kind != sequencePattern {
+		return len(nodes) > 0 && captured.match(first, nodes[0]) && captured.matchChildren(patterns[1:], nodes[1:])
+	}
+	for count := 0; count <= len(nodes); count++ {
+		attempt := captured.clone()
+		if attempt.matchSequence(first, nodes[:count]) && attempt.matchChildren(patterns[1:], nodes[count:]) {
+			*captured = *attempt
+			return true
+		}
+	}
+	return false
+}
+
+func (captured *bindings) matchSequence(pattern *patternNode, nodes []*langkit.Token) bool {
+	for _, node := range nodes {
+		if node == nil || (pattern.predicate != nil && !pattern.predicate(node)) {
+			return false
+		}
+	}
+	if pattern.name == "" {
+		return true
+	}
+	if bound, ok := captured.sequences[pattern.name]; ok {
+		if len(bound) != len(nodes) {
+			return false
+		}
+		for i := range bound {
+			if !equalTrees(bound[i], nodes[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	captured.sequences[pattern.name] = append([]*langkit.Token{}, nodes...)
+	return true
+}
+
+// Compares the symbols, values and children of trees, ignoring where
+// they appear in the source
+func equalTrees(left *langkit.Token, right *langkit.Token) bool {
+	if left == nil || right == nil {
+		return left == right
+	}
+	if left.Symbol != right.Symbol || left.Value != right.Value || len(left.Children) != len(right.Children) {
+		return false
+	}
+	for i := range left.Children {
+		if !equalTrees(left.Children[i], right.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Builds trees from the captures of a match. Templates are written
+// like patterns without wildcards or predicates: $name and $name...
+// insert what the pattern captured, () inserts a missing child, and
+// a node written without a value gets its symbol as value.
+type Template struct {
+	root      *patternNode
+	variables map[string]patternKind
+}
+
+func CompileTemplate(source string) (*Template, error) {
+	parser := newPatternParser(source, nil, true)
+	root, err := parser.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Template{root: root, variables: parser.captures}, nil
+}
+
+// Builds a tree from the captures of the match, sharing the captured
+// nodes. A template of () builds nil.
+func (template *Template) Instantiate(match Match) (*langkit.Token, error) {
+	nodes, err := template.instantiate(template.root, match)
+	if err != nil {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+func (template *Template) instantiate(pattern *patternNode, match Match) ([]*langkit.Token, error) {
+	switch pattern.kind {
+	case nilPattern:
+		return []*langkit.Token{nil}, nil
+	case anyPattern:
+		node, ok := match.Captures[pattern.name]
+		if !ok {
+			return nil, fmt.Errorf("patternerror: $%v was not captured", pattern.name)
+		}
+		return []*langkit.Token{node}, nil
+	case sequencePattern:
+		nodes, ok := match.Sequences[pattern.name]
+		if !ok {
+			return nil, fmt.Errorf("patternerror: $%v... was not captured", pattern.name)
+		}
+		return nodes, nil
+	}
+	children := []*langkit.Token{}
+	for _, child := range pattern.children {
+		nodes, err := template.instantiate(child, match)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, nodes...)
+	}
+	return []*langkit.Token{{
+		Symbol:   pattern.symbol,
+		Value:    pattern.value,
+		Arity:    len(children),
+		Children: children,
+	}}, nil
+}
+
+// Rewrites the tree bottom up, replacing each node the pattern
+// matches with the template built from the match. Replacements are
+// not matched again. The original tree is left unchanged.
+func Replace(tree *langkit.Token, pattern *Pattern, template *Template) (*langkit.Token, error) {
+	names := []string{}
+	for name := range template.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if kind, ok := pattern.captures[name]; !ok || kind != template.variables[name] {
+			return nil, fmt.Errorf("patternerror: the template uses %v, which the pattern does not capture", variableName(name, template.variables[name]))
+		}
+	}
+	var err error
+	rewritten := Rewrite(tree, func(cursor *Cursor) *langkit.Token {
+		match, ok := pattern.Match(cursor.Node())
+		if !ok || err != nil {
+			return cursor.Node()
+		}
+		var replacement *langkit.Token
+		replacement, err = template.Instantiate(match)
+		return replacement
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rewritten, nil
+}
+
+func variableName(name string, kind patternKind) string {
+	if kind == sequencePattern {
+		return "$" + name + "..."
+	}
+	return "$" + name
+}
+
+type patternParser struct {
+	*sexprScanner
+	predicates map[string]Predicate
+	template   bool
+	captures   map[string]patternKind
+}
+
+func newPatternParser(source string, predicates map[string]Predicate, template bool) *patternParser {
+	return &patternParser{
+		sexprScanner: &sexprScanner{source: source, errorKind: "patternerror"},
+		predicates:   predicates,
+		template:     template,
+		captures:     map[string]patternKind{},
+	}
+}
+
+func (parser *patternParser) parse() (*patternNode, error) {
+	if err := parser.validate(); err != nil {
+		return nil, err
+	}
+	root, err := parser.element()
+	if err != nil {
+		return nil, err
+	}
+	if root.kind == sequencePattern {
+		return nil, parser.errorf("a sequence can only match children")
+	}
+	if parser.peek() != 0 {
+		return nil, parser.errorf("unexpected text after the pattern")
+	}
+	return root, nil
+}
+
+func (parser *patternParser) element() (*patternNode, error) {
+	if parser.peek() == '(' {
+		return parser.node()
+	}
+	text := parser.atom()
+	if text == "" {
+		return nil, parser.errorf("expected a pattern")
+	}
+	return parser.variable(text)
+}
+
+// Reads one of _, ..., $name or $name..., each optionally followed by
+// a predicate
+func (parser *patternParser) variable(text string) (*patternNode, error) {
+	if parser.template && strings.Contains(text, ":") {
+		return nil, parser.errorf("templates cannot use %v", text)
+	}
+	node := &patternNode{kind: anyPattern}
+	name := text
+	if i := strings.Index(name, ":"); i >= 0 {
+		predicate, ok := parser.predicates[name[i+1:]]
+		if !ok {
+			return nil, parser.errorf("unknown predicate %v", name[i+1:])
+		}
+		node.predicate = predicate
+		name = name[:i]
+	}
+	if strings.HasSuffix(name, "...") {
+		node.kind = sequencePattern
+		name = strings.TrimSuffix(name, "...")
+	}
+	switch {
+	case (node.kind == anyPattern && name == "_") || (node.kind == sequencePattern && name == ""):
+	case strings.HasPrefix(name, "$") && isVariableName(name[1:]):
+		node.name = name[1:]
+	default:
+		return nil, parser.errorf("unexpected %v", text)
+	}
+	if parser.template && (node.name == "" || node.predicate != nil) {
+		return nil, parser.errorf("templates cannot use %v", text)
+	}
+	if node.name != "" {
+		if kind, ok := parser.captures[node.name]; ok && kind != node.kind {
+			return nil, parser.errorf("$%v is used both for a node and for a sequence", node.name)
+		}
+		parser.captures[node.name] = node.kind
+	}
+	return node, nil
+}
+
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, char := range name {
+		if !(char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+func (parser *patternParser) node() (*patternNode, error) {
+	parser.offset++
+	if parser.peek() == ')' {
+		parser.offset++
+		return &patternNode{kind: nilPattern}, nil
+	}
+	node := &patternNode{kind: nodePattern}
+	start := parser.offset
+	if parser.peek() != '"' && parser.atom() == "_" {
+		if parser.template {
+			return nil, parser.errorf("templates cannot use _")
+		}
+		node.anySymbol = true
+	} else {
+		parser.offset = start
+		symbol, err := parser.symbol()
+		if err != nil {
+			return nil, err
+		}
+		node.symbol = symbol
+	}
+	if parser.peek() == '"' {
+		value, err := parser.quoted()
+		if err != nil {
+			return nil, err
+		}
+		node.value = value
+		node.hasValue = true
+	} else if parser.template {
+		node.value = string(node.symbol)
+		node.hasValue = true
+	}
+	for parser.peek() != ')' {
+		if parser.peek() == 0 {
+			return nil, parser.errorf("expected %q", ')')
+		}
+		child, err := parser.element()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, child)
+	}
+	parser.offset++
+	return node, nil
+}
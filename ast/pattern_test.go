@@ -0,0 +1,206 @@
+package ast
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nicholasbailey/langkit"
+	"github.com/nicholasbailey/langkit/toyscript/engine"
+)
+
+var testPredicates = map[string]Predicate{
+	"literal": func(node *langkit.Token) bool {
+		return node.Symbol == langkit.IntLiteral || node.Symbol == langkit.FloatLiteral || node.Symbol == langkit.StringLiteral
+	},
+}
+
+// Describes the captures of a match in name order
+func describeCaptures(match Match) string {
+	parts := []string{}
+	for name, node := range match.Captures {
+		parts = append(parts, "$"+name+"="+shape(node))
+	}
+	for name, nodes := range match.Sequences {
+		shapes := []string{}
+		for _, node := range nodes {
+			shapes = append(shapes, shape(node))
+		}
+		parts = append(parts, "$"+name+"...=["+strings.Join(shapes, " ")+"]")
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func TestPatternMatch(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		source   string
+		matches  bool
+		captures string
+	}{
+		{`(+ $x (INT "0"))`, "a + 0", true, "$x=a"},
+		{`(+ $x (INT "0"))`, "a + 1", false, ""},
+		{`(+ $x (INT "0"))`, "a + 0 + 0", true, "$x=(+ a 0)"},
+		{`(+ $x $x)`, "a * b + a * b", true, "$x=(* a b)"},
+		{`(+ $x $x)`, "a * b + a * c", false, ""},
+		{`(NAME)`, "a", true, ""},
+		{`(NAME "b")`, "a", false, ""},
+		{`_`, "f(a)", true, ""},
+		{`(_ $x)`, "-a", true, "$x=a"},
+		{`(_ $x:literal)`, "-a", false, ""},
+		{`(_ $x:literal)`, "-1.5", true, "$x=1.5"},
+		{`(FUNCTIONINVOCATION "(" (NAME "f") ...)`, "f(1, 2)", true, ""},
+		{`(FUNCTIONINVOCATION "(" (NAME "f") ...)`, "g(1, 2)", false, ""},
+		{`(FUNCTIONINVOCATION $f $first $rest...)`, "f(1, 2, 3)", true, "$f=f $first=1 $rest...=[2 3]"},
+		{`(FUNCTIONINVOCATION $f $first $rest...)`, "f()", false, ""},
+		{`(FUNCTIONINVOCATION _ $before... (STRING) $after...)`, `f(a, b, "s", c)`, true, "$after...=[c] $before...=[a b]"},
+		{`(FUNCTIONINVOCATION _ ...:literal)`, `f(1, "s")`, true, ""},
+		{`(FUNCTIONINVOCATION _ ...:literal)`, `f(1, s)`, false, ""},
+		{`(FUNCTIONINVOCATION _ $xs... $xs...)`, `f(a, b, a, b)`, true, "$xs...=[a b]"},
+		{`(FUNCTIONINVOCATION _ $xs... $xs...)`, `f(a, b, a)`, false, ""},
+		{`(≠ $x (INT "0"))`, "a + 1 ≠ 0", true, "$x=(+ a 1)"},
+		{`(≠ $x (INT "0"))`, "a + 0", false, ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern+" "+testCase.source, func(t *testing.T) {
+			pattern, err := CompilePattern(testCase.pattern, testPredicates)
+			if err != nil {
+				t.Fatalf("Unexpected pattern error %v", err)
+			}
+			match, ok := pattern.Match(parse(t, testCase.source))
+			if ok != testCase.matches {
+				t.Fatalf("Expected match to be %v", testCase.matches)
+			}
+			if ok && describeCaptures(match) != testCase.captures {
+				t.Fatalf("Expected captures %v, got %v", testCase.captures, describeCaptures(match))
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	matches := MustCompilePattern(`(+ _ _)`, nil).Search(parse(t, "f(a + b + c, d + e)"))
+	found := []string{}
+	for _, match := range matches {
+		found = append(found, fmt.Sprintf("%v@%v-%v", shape(match.Node), match.Span.Start.Offset, match.Span.End.Offset))
+	}
+	expected := "[(+ (+ a b) c)@2-11 (+ a b)@2-7 (+ d e)@13-18]"
+	if fmt.Sprint(found) != expected {
+		t.Fatalf("Expected %v, got %v", expected, found)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		template string
+		source   string
+		expected string
+	}{
+		{`(+ $x (INT "0"))`, `$x`, "a * (b + 0 + 0)", "(* a b)"},
+		{`(- (- $x))`, `$x`, "- - - a", "(- a)"},
+		{`(FUNCTIONINVOCATION (NAME "f") $args...)`, `(FUNCTIONINVOCATION "(" (NAME "g") $args... (INT "0"))`, "f(a, f(b))", "(( g a (( g b 0) 0)"},
+		{`(INT "0")`, `()`, "f(0, a, 0)", "(( f a)"},
+		{`(+ $x $y)`, `(+ $y $x)`, "a + b", "(+ b a)"},
+		{`(+ $x $y)`, `(+ $y $x)`, "a * b", "(* a b)"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern+" "+testCase.source, func(t *testing.T) {
+			tree := parse(t, testCase.source)
+			original := shape(tree)
+			rewritten, err := Replace(tree, MustCompilePattern(testCase.pattern, nil), mustCompileTemplate(t, testCase.template))
+			if err != nil {
+				t.Fatalf("Unexpected replacement error %v", err)
+			}
+			if actual := shape(rewritten); actual != testCase.expected {
+				t.Fatalf("Expected %v, got %v", testCase.expected, actual)
+			}
+			if shape(tree) != original {
+				t.Fatalf("Expected the original tree to be unchanged")
+			}
+		})
+	}
+}
+
+func mustCompileTemplate(t *testing.T, source string) *Template {
+	template, err := CompileTemplate(source)
+	if err != nil {
+		t.Fatalf("Unexpected template error %v", err)
+	}
+	return template
+}
+
+func TestSearchAndReplaceToyscript(t *testing.T) {
+	file, err := os.Open("../test_scripts/test_fizzbuzz.toy")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer file.Close()
+	parser := langkit.NewParser(langkit.NewFileLexer(file, "test_fizzbuzz.toy", engine.BuildToyscriptLanguageSpec()))
+	statements, err := parser.Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	program := &langkit.Token{Symbol: langkit.Program, Children: statements}
+
+	prints := MustCompilePattern(`(FUNCTIONINVOCATION (NAME "print") $arg)`, nil)
+	lines := []int{}
+	for _, match := range prints.Search(program) {
+		lines = append(lines, match.Span.Start.Line)
+	}
+	if fmt.Sprint(lines) != "[7 9 11 13]" {
+		t.Fatalf("Expected prints on lines [7 9 11 13], got %v", lines)
+	}
+
+	template := mustCompileTemplate(t, `(FUNCTIONINVOCATION "(" (NAME "log") (STRING "fizzbuzz") $arg)`)
+	rewritten, err := Replace(program, prints, template)
+	if err != nil {
+		t.Fatalf("Unexpected replacement error %v", err)
+	}
+	logs := MustCompilePattern(`(FUNCTIONINVOCATION (NAME "log") (STRING "fizzbuzz") _)`, nil)
+	if len(prints.Search(rewritten)) != 0 || len(logs.Search(rewritten)) != 4 || len(prints.Search(program)) != 4 {
+		t.Fatalf("Expected every print of the copy, and none of the original, to be replaced")
+	}
+}
+
+func TestPatternErrors(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		template string
+		message  string
+	}{
+		{"", "", "expected a pattern"},
+		{"(+ $x", "", "expected ')'"},
+		{"(+ x)", "", "unexpected x"},
+		{"(+ $x:pure)", "", "unknown predicate pure"},
+		{"...", "", "can only match children"},
+		{"(+ $x $x...)", "", "both for a node and for a sequence"},
+		{"(+ _ _) _", "", "unexpected text"},
+		{`(STRING "a)`, "", "unterminated string"},
+		{"(\xe2\x89 _ _)", "", "invalid UTF-8"},
+		{"(≠ $x $y)", "(≠ $x \xe2\x89)", "invalid UTF-8"},
+		{"(+ $x $y)", "(_ $x)", "templates cannot use _"},
+		{"(+ $x $y)", "(+ $x ...)", "templates cannot use ..."},
+		{"(+ $x $y)", "(+ $x $y:literal)", "templates cannot use $y:literal"},
+		{"(+ $x $y)", "(+ $x $z)", "uses $z, which"},
+		{"(+ $x $y...)", "(+ $x $y)", "uses $y, which"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern+" "+testCase.template, func(t *testing.T) {
+			pattern, err := CompilePattern(testCase.pattern, testPredicates)
+			var template *Template
+			if err == nil {
+				template, err = CompileTemplate(testCase.template)
+			}
+			if err == nil {
+				_, err = Replace(parse(t, "a + b"), pattern, template)
+			}
+			if err == nil || !strings.Contains(err.Error(), testCase.message) || !strings.HasPrefix(err.Error(), "patternerror: ") {
+				t.Fatalf("Expected an error containing %q, got %v", testCase.message, err)
+			}
+		})
+	}
+}
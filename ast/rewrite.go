@@ -0,0 +1,57 @@
+package ast
+
+import "github.com/nicholasbailey/langkit"
+
+// Rewrites a node of a tree. Returning the node keeps it, returning
+// another node replaces it and returning nil deletes it. Rewriters
+// must not change the nodes they are given.
+type Rewriter func(cursor *Cursor) *langkit.Token
+
+// Rewrites a tree bottom up, leaving the original unchanged. Each node
+// is passed to the rewriter after its children have been rewritten,
+// copied if any of them changed, so that the cursor's node reflects
+// the rewritten children while its path holds the original
+// ancestors. Subtrees the rewriter keeps are shared with the original
+// tree. Returns nil if the root is deleted.
+func Rewrite(tree *langkit.Token, rewriter Rewriter) *langkit.Token {
+	if tree == nil {
+		return nil
+	}
+	cursor := &Cursor{}
+	return rewrite(cursor, tree, -1, rewriter)
+}
+
+func rewrite(cursor *Cursor, node *langkit.Token, index int, rewriter Rewriter) *langkit.Token {
+	cursor.push(node, index)
+	defer cursor.pop()
+
+	var children []*langkit.Token
+	for i, child := range node.Children {
+		rewritten := child
+		if child != nil {
+			rewritten = rewrite(cursor, child, i, rewriter)
+		}
+		if rewritten != child && children == nil {
+			children = append([]*langkit.Token{}, node.Children[:i]...)
+		}
+		if children != nil && rewritten != nil {
+			children = append(children, rewritten)
+		}
+	}
+	if children != nil {
+		node = withChildren(node, children)
+		cursor.path[len(cursor.path)-1] = node
+	}
+	return rewriter(cursor)
+}
+
+// Copies a node with new children, keeping its arity in step when it
+// counts the children
+func withChildren(node *langkit.Token, children []*langkit.Token) *langkit.Token {
+	copied := *node
+	if copied.Arity == len(node.Children) {
+		copied.Arity = len(children)
+	}
+	copied.Children = children
+	return &copied
+}
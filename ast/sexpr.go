@@ -0,0 +1,309 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nicholasbailey/langkit"
+)
+
+// Writes the tree as an S-expression. Each node is written as
+//
+//	(SYMBOL "value" @line:col ["file" line:col:offset line:col:offset] #arity children...)
+//
+// where builtin symbols such as (INT) are written bare as INT, the
+// value is left out when it is the symbol itself, the position, span
+// and arity are left out when unset or, for the arity, when it is the
+// number of children, and a missing child is written as ().
+func EncodeSExpr(writer io.Writer, tree *langkit.Token, options EncodeOptions) error {
+	var builder strings.Builder
+	writeSExpr(&builder, tree, options, 0)
+	builder.WriteString("\n")
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+func writeSExpr(builder *strings.Builder, token *langkit.Token, options EncodeOptions, depth int) {
+	if token == nil {
+		builder.WriteString("()")
+		return
+	}
+	builder.WriteString("(")
+	builder.WriteString(symbolAtom(token.Symbol))
+	if token.Value != string(token.Symbol) {
+		builder.WriteString(" " + strconv.Quote(token.Value))
+	}
+	if !options.OmitPositions {
+		if token.Line != 0 || token.Col != 0 {
+			builder.WriteString(fmt.Sprintf(" @%v:%v", token.Line, token.Col))
+		}
+		if token.Span.IsValid() {
+			builder.WriteString(" [")
+			if token.Span.File != "" {
+				builder.WriteString(strconv.Quote(token.Span.File) + " ")
+			}
+			builder.WriteString(positionAtom(token.Span.Start) + " " + positionAtom(token.Span.End) + "]")
+		}
+	}
+	if token.Arity != len(token.Children) {
+		builder.WriteString(fmt.Sprintf(" #%v", token.Arity))
+	}
+	for _, child := range token.Children {
+		if options.Indent == "" {
+			builder.WriteString(" ")
+		} else {
+			builder.WriteString("\n" + strings.Repeat(options.Indent, depth+1))
+		}
+		writeSExpr(builder, child, options, depth+1)
+	}
+	builder.WriteString(")")
+}
+
+// Writes builtin symbols bare and quotes symbols that would otherwise
+// be read back differently
+func symbolAtom(symbol langkit.Symbol) string {
+	text := string(symbol)
+	if inner, ok := builtinName(text); ok {
+		return inner
+	}
+	if isUpperName(text) || !isBareAtom(text) {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+func builtinName(text string) (string, bool) {
+	if len(text) > 2 && text[0] == '(' && text[len(text)-1] == ')' && isUpperName(text[1:len(text)-1]) {
+		return text[1 : len(text)-1], true
+	}
+	return "", false
+}
+
+func isUpperName(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, char := range text {
+		if char < 'A' || char > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the text reads back as a single atom that is not one of the
+// wildcards of patterns
+func isBareAtom(text string) bool {
+	if text == "" || text == "_" || text == "..." || strings.HasPrefix(text, "$") {
+		return false
+	}
+	for _, char := range text {
+		if isDelimiter(char) || char == '@' || char == '#' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDelimiter(char rune) bool {
+	return unicode.IsSpace(char) || strings.ContainsRune("()[]\"", char)
+}
+
+func positionAtom(position langkit.Position) string {
+	return fmt.Sprintf("%v:%v:%v", position.Line, position.Col, position.Offset)
+}
+
+// Reads a tree written by EncodeSExpr. The tokens have no parsing
+// functions or binding powers.
+func DecodeSExpr(reader io.Reader) (*langkit.Token, error) {
+	source, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	scanner := &sexprScanner{source: string(source), errorKind: "decodeerror"}
+	if err := scanner.validate(); err != nil {
+		return nil, err
+	}
+	if scanner.peek() != '(' || strings.HasPrefix(scanner.source[scanner.offset:], "()") {
+		return nil, scanner.errorf("expected a node")
+	}
+	tree, err := scanner.node()
+	if err != nil {
+		return nil, err
+	}
+	if scanner.peek() != 0 {
+		return nil, scanner.errorf("unexpected text after the tree")
+	}
+	return tree, nil
+}
+
+type sexprScanner struct {
+	source    string
+	offset    int
+	errorKind string
+}
+
+// Fails at the first byte of the source that is not valid UTF-8
+func (scanner *sexprScanner) validate() error {
+	for scanner.offset < len(scanner.source) {
+		char, size := utf8.DecodeRuneInString(scanner.source[scanner.offset:])
+		if char == utf8.RuneError && size == 1 {
+			return scanner.errorf("invalid UTF-8")
+		}
+		scanner.offset += size
+	}
+	scanner.offset = 0
+	return nil
+}
+
+func (scanner *sexprScanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%v: %v at offset %v", scanner.errorKind, fmt.Sprintf(format, args...), scanner.offset)
+}
+
+// Skips whitespace and returns the first byte of the next character,
+// or 0 at the end
+func (scanner *sexprScanner) peek() byte {
+	for scanner.offset < len(scanner.source) {
+		char, size := utf8.DecodeRuneInString(scanner.source[scanner.offset:])
+		if !unicode.IsSpace(char) {
+			break
+		}
+		scanner.offset += size
+	}
+	if scanner.offset == len(scanner.source) {
+		return 0
+	}
+	return scanner.source[scanner.offset]
+}
+
+func (scanner *sexprScanner) expect(char byte) error {
+	if scanner.peek() != char {
+		return scanner.errorf("expected %q", char)
+	}
+	scanner.offset++
+	return nil
+}
+
+func (scanner *sexprScanner) atom() string {
+	scanner.peek()
+	start := scanner.offset
+	for scanner.offset < len(scanner.source) {
+		char, size := utf8.DecodeRuneInString(scanner.source[scanner.offset:])
+		if isDelimiter(char) {
+			break
+		}
+		scanner.offset += size
+	}
+	return scanner.source[start:scanner.offset]
+}
+
+func (scanner *sexprScanner) quoted() (string, error) {
+	scanner.peek()
+	start := scanner.offset
+	for end := start + 1; end < len(scanner.source); end++ {
+		switch scanner.source[end] {
+		case '\\':
+			end++
+		case '"':
+			text, err := strconv.Unquote(scanner.source[start : end+1])
+			if err != nil {
+				return "", scanner.errorf("invalid string %v", scanner.source[start:end+1])
+			}
+			scanner.offset = end + 1
+			return text, nil
+		}
+	}
+	return "", scanner.errorf("unterminated string")
+}
+
+// Reads a symbol, whether quoted or bare
+func (scanner *sexprScanner) symbol() (langkit.Symbol, error) {
+	if scanner.peek() == '"' {
+		text, err := scanner.quoted()
+		return langkit.Symbol(text), err
+	}
+	text := scanner.atom()
+	if text == "" {
+		return "", scanner.errorf("expected a symbol")
+	}
+	if isUpperName(text) {
+		return langkit.Symbol("(" + text + ")"), nil
+	}
+	return langkit.Symbol(text), nil
+}
+
+func (scanner *sexprScanner) node() (*langkit.Token, error) {
+	if err := scanner.expect('('); err != nil {
+		return nil, err
+	}
+	if scanner.peek() == ')' {
+		scanner.offset++
+		return nil, nil
+	}
+	symbol, err := scanner.symbol()
+	if err != nil {
+		return nil, err
+	}
+	token := &langkit.Token{Symbol: symbol, Value: string(symbol)}
+	if scanner.peek() == '"' {
+		if token.Value, err = scanner.quoted(); err != nil {
+			return nil, err
+		}
+	}
+	if scanner.peek() == '@' {
+		scanner.offset++
+		if _, err := fmt.Sscanf(scanner.atom(), "%d:%d", &token.Line, &token.Col); err != nil {
+			return nil, scanner.errorf("invalid position")
+		}
+	}
+	if scanner.peek() == '[' {
+		if token.Span, err = scanner.span(); err != nil {
+			return nil, err
+		}
+	}
+	arity := -1
+	if scanner.peek() == '#' {
+		scanner.offset++
+		if arity, err = strconv.Atoi(scanner.atom()); err != nil {
+			return nil, scanner.errorf("invalid arity")
+		}
+	}
+	for scanner.peek() != ')' {
+		if scanner.peek() != '(' {
+			return nil, scanner.errorf("expected a child or %q", ')')
+		}
+		child, err := scanner.node()
+		if err != nil {
+			return nil, err
+		}
+		token.Children = append(token.Children, child)
+	}
+	scanner.offset++
+	token.Arity = len(token.Children)
+	if arity >= 0 {
+		token.Arity = arity
+	}
+	return token, nil
+}
+
+func (scanner *sexprScanner) span() (langkit.Span, error) {
+	span := langkit.Span{}
+	scanner.offset++
+	if scanner.peek() == '"' {
+		file, err := scanner.quoted()
+		if err != nil {
+			return span, err
+		}
+		span.File = file
+	}
+	for _, position := range []*langkit.Position{&span.Start, &span.End} {
+		if _, err := fmt.Sscanf(scanner.atom(), "%d:%d:%d", &position.Line, &position.Col, &position.Offset); err != nil {
+			return span, scanner.errorf("invalid span position")
+		}
+	}
+	return span, scanner.expect(']')
+}
@@ -0,0 +1,131 @@
+// Package ast traverses, rewrites, encodes and searches the trees of
+// tokens produced by langkit parsers.
+package ast
+
+import "github.com/nicholasbailey/langkit"
+
+// Tells Walk how to continue after visiting a node
+type Action int
+
+const (
+	Continue Action = iota
+	// Returned on entering a node, skips its children and the call
+	// to Leave for it
+	SkipChildren
+	// Ends the walk
+	Stop
+)
+
+// Receives the nodes of a tree as Walk visits them
+type Visitor interface {
+	// Called before the children of the node are visited
+	Enter(cursor *Cursor) Action
+	// Called after the children of the node are visited
+	Leave(cursor *Cursor) Action
+}
+
+// A Visitor built from functions, either of which may be nil
+type VisitorFuncs struct {
+	EnterFunc func(cursor *Cursor) Action
+	LeaveFunc func(cursor *Cursor) Action
+}
+
+func (funcs VisitorFuncs) Enter(cursor *Cursor) Action {
+	if funcs.EnterFunc == nil {
+		return Continue
+	}
+	return funcs.EnterFunc(cursor)
+}
+
+func (funcs VisitorFuncs) Leave(cursor *Cursor) Action {
+	if funcs.LeaveFunc == nil {
+		return Continue
+	}
+	return funcs.LeaveFunc(cursor)
+}
+
+// Locates the node being visited within the tree
+type Cursor struct {
+	path    []*langkit.Token
+	indices []int
+}
+
+// The node being visited
+func (cursor *Cursor) Node() *langkit.Token {
+	return cursor.path[len(cursor.path)-1]
+}
+
+// The parent of the node, or nil at the root
+func (cursor *Cursor) Parent() *langkit.Token {
+	if len(cursor.path) < 2 {
+		return nil
+	}
+	return cursor.path[len(cursor.path)-2]
+}
+
+// The position of the node among its parent's children, or -1 at
+// the root
+func (cursor *Cursor) Index() int {
+	return cursor.indices[len(cursor.indices)-1]
+}
+
+// The ancestors of the node, starting from the root
+func (cursor *Cursor) Path() []*langkit.Token {
+	return append([]*langkit.Token{}, cursor.path[:len(cursor.path)-1]...)
+}
+
+// The number of ancestors of the node
+func (cursor *Cursor) Depth() int {
+	return len(cursor.path) - 1
+}
+
+func (cursor *Cursor) push(node *langkit.Token, index int) {
+	cursor.path = append(cursor.path, node)
+	cursor.indices = append(cursor.indices, index)
+}
+
+func (cursor *Cursor) pop() {
+	cursor.path = cursor.path[:len(cursor.path)-1]
+	cursor.indices = cursor.indices[:len(cursor.indices)-1]
+}
+
+// Visits the tree depth first, calling Enter on each node before its
+// children and Leave after them. Returns false if the visitor stopped
+// the walk.
+func Walk(tree *langkit.Token, visitor Visitor) bool {
+	if tree == nil {
+		return true
+	}
+	cursor := &Cursor{}
+	return walk(cursor, tree, -1, visitor)
+}
+
+func walk(cursor *Cursor, node *langkit.Token, index int, visitor Visitor) bool {
+	cursor.push(node, index)
+	defer cursor.pop()
+	switch visitor.Enter(cursor) {
+	case Stop:
+		return false
+	case SkipChildren:
+		return true
+	}
+	for i, child := range node.Children {
+		if child != nil && !walk(cursor, child, i, visitor) {
+			return false
+		}
+	}
+	return visitor.Leave(cursor) != Stop
+}
+
+// Calls inspect for each node of the tree in depth first order,
+// skipping the children of nodes for which it returns false
+func Inspect(tree *langkit.Token, inspect func(node *langkit.Token) bool) {
+	Walk(tree, VisitorFuncs{
+		EnterFunc: func(cursor *Cursor) Action {
+			if inspect(cursor.Node()) {
+				return Continue
+			}
+			return SkipChildren
+		},
+	})
+}
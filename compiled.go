@@ -0,0 +1,209 @@
+package langkit
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// An immutable specification with its lexical tables precomputed, so
+// that any number of lexers and parsers may share it concurrently.
+// Extend returns an error, while the other methods that would change
+// it, which cannot report errors, panic.
+type compiledSpecification struct {
+	*languageSpecificationImpl
+	identifierCharacters      [utf8.RuneSelf]bool
+	identifierStartCharacters [utf8.RuneSelf]bool
+	operators                 *operatorNode
+	blockEnds                 map[Symbol]bool
+}
+
+// A node of the trie of defined symbols, reached by reading the
+// characters of a prefix of one of them
+type operatorNode struct {
+	children map[rune]*operatorNode
+	defined  bool
+}
+
+// Implemented by specifications whose operators the lexer can match
+// through a trie rather than a lookup per character
+type operatorLexicon interface {
+	operatorTrie() *operatorNode
+}
+
+func (spec *languageSpecificationImpl) Compile() LanguageSpecification {
+	compiled := &compiledSpecification{
+		languageSpecificationImpl: spec.Clone().(*languageSpecificationImpl),
+		operators:                 &operatorNode{children: map[rune]*operatorNode{}},
+		blockEnds:                 map[Symbol]bool{},
+	}
+	for _, end := range compiled.blockDelimiters {
+		compiled.blockEnds[end] = true
+	}
+	for char := rune(0); char < utf8.RuneSelf; char++ {
+		compiled.identifierCharacters[char] = compiled.languageSpecificationImpl.IsIdentifierCharacter(char)
+		compiled.identifierStartCharacters[char] = compiled.languageSpecificationImpl.IsIdentifierStartChararacter(char)
+	}
+	for symbol := range compiled.symbols {
+		node := compiled.operators
+		for _, char := range string(symbol) {
+			child, found := node.children[char]
+			if !found {
+				child = &operatorNode{children: map[rune]*operatorNode{}}
+				node.children[char] = child
+			}
+			node = child
+		}
+		node.defined = true
+	}
+	return compiled
+}
+
+func (compiled *compiledSpecification) Compile() LanguageSpecification {
+	return compiled
+}
+
+func (compiled *compiledSpecification) operatorTrie() *operatorNode {
+	return compiled.operators
+}
+
+func (compiled *compiledSpecification) IsIdentifierCharacter(char rune) bool {
+	if char >= 0 && char < utf8.RuneSelf {
+		return compiled.identifierCharacters[char]
+	}
+	return compiled.languageSpecificationImpl.IsIdentifierCharacter(char)
+}
+
+func (compiled *compiledSpecification) IsIdentifierStartChararacter(char rune) bool {
+	if char >= 0 && char < utf8.RuneSelf {
+		return compiled.identifierStartCharacters[char]
+	}
+	return compiled.languageSpecificationImpl.IsIdentifierStartChararacter(char)
+}
+
+func (compiled *compiledSpecification) IsAnyBlockEnd(symbol Symbol) bool {
+	return compiled.blockEnds[symbol]
+}
+
+func (compiled *compiledSpecification) GetNumberOptions() *NumberOptions {
+	return copyNumberOptions(compiled.numberOptions)
+}
+
+func (compiled *compiledSpecification) Clone() LanguageSpecification {
+	return compiled.languageSpecificationImpl.Clone()
+}
+
+func (compiled *compiledSpecification) immutable(operation string) {
+	panic(fmt.Sprintf("specificationerror: cannot %v a compiled specification", operation))
+}
+
+func (compiled *compiledSpecification) Define(symbol Symbol, bindingPower int, arity int, nud NudFunction, led LedFunction, std StdFunction) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineInfix(symbol Symbol, bindingPower int) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineInfixRight(symbol Symbol, bindingPower int) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineValue(symbol Symbol) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefinePrefix(symbol Symbol, bindingPower int) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefinePostfix(symbol Symbol, bindingPower int) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineTernary(question Symbol, colon Symbol, bindingPower int) {
+	compiled.immutable("define " + string(question) + " in")
+}
+
+func (compiled *compiledSpecification) DefineParens(openParens Symbol, closeParens Symbol) {
+	compiled.immutable("define " + string(openParens) + " in")
+}
+
+func (compiled *compiledSpecification) DefineCall(open Symbol, close Symbol, separator Symbol) {
+	compiled.immutable("define " + string(open) + " in")
+}
+
+func (compiled *compiledSpecification) DefineIndex(open Symbol, close Symbol) {
+	compiled.immutable("define " + string(open) + " in")
+}
+
+func (compiled *compiledSpecification) DefineMemberAccess(dot Symbol) {
+	compiled.immutable("define " + string(dot) + " in")
+}
+
+func (compiled *compiledSpecification) DefineListLiteral(open Symbol, close Symbol, separator Symbol) {
+	compiled.immutable("define " + string(open) + " in")
+}
+
+func (compiled *compiledSpecification) DefineMapLiteral(open Symbol, close Symbol, colon Symbol, separator Symbol) {
+	compiled.immutable("define " + string(open) + " in")
+}
+
+func (compiled *compiledSpecification) DefineQuotes(openQuote rune, closeQuote rune, literalType Symbol) {
+	compiled.immutable("define " + string(openQuote) + " in")
+}
+
+func (compiled *compiledSpecification) DefineQuotesWithOptions(openQuote string, closeQuote string, literalType Symbol, options QuoteOptions) {
+	compiled.immutable("define " + openQuote + " in")
+}
+
+func (compiled *compiledSpecification) DefineNumbers(options NumberOptions) {
+	compiled.immutable("define numbers in")
+}
+
+func (compiled *compiledSpecification) DefineLineComment(prefix string) {
+	compiled.immutable("define " + prefix + " in")
+}
+
+func (compiled *compiledSpecification) DefineBlockComment(open string, close string, nestable bool) {
+	compiled.immutable("define " + open + " in")
+}
+
+func (compiled *compiledSpecification) DefineStatementTerminator(symbol Symbol) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineNewlineTerminator(symbol Symbol) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineGrouping(open Symbol, close Symbol) {
+	compiled.immutable("define " + string(open) + " in")
+}
+
+func (compiled *compiledSpecification) DefineEmpty(symbol Symbol) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineBlock(startSymbol Symbol, endSymbol Symbol) {
+	compiled.immutable("define " + string(startSymbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineIndentedBlock(startSymbol Symbol) {
+	compiled.immutable("define " + string(startSymbol) + " in")
+}
+
+func (compiled *compiledSpecification) DefineStatment(symbol Symbol, std StdFunction) {
+	compiled.immutable("define " + string(symbol) + " in")
+}
+
+func (compiled *compiledSpecification) SetStrict(strict bool) {
+	compiled.immutable("change the strictness of")
+}
+
+func (compiled *compiledSpecification) Extend(base LanguageSpecification) error {
+	return fmt.Errorf("specificationerror: cannot extend a compiled specification")
+}
+
+func (compiled *compiledSpecification) Remove(symbol Symbol) {
+	compiled.immutable("remove " + string(symbol) + " from")
+}
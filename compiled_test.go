@@ -0,0 +1,148 @@
+package langkit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func lexValues(spec LanguageSpecification, source string) (string, error) {
+	lexer := NewLexer(strings.NewReader(source), spec)
+	values := []string{}
+	for {
+		token, err := lexer.Next()
+		if err != nil {
+			return strings.Join(values, " "), err
+		}
+		if token.Symbol == EOF {
+			return strings.Join(values, " "), nil
+		}
+		values = append(values, fmt.Sprintf("%v:%v", token.Symbol, token.Value))
+	}
+}
+
+func TestCompiledSpecificationLexesLikeOriginal(t *testing.T) {
+	spec := makeDocumentedLanguage()
+	spec.DefineInfix("=", 10)
+	spec.DefineInfix("==", 50)
+	spec.DefineInfix("===", 50)
+	spec.DefineLineComment("//")
+	compiled := spec.Compile()
+
+	inputs := []string{
+		"a === b == c = d",
+		"naïve + ünïcode.x",
+		"f(\"a\", [1, 2.5]) // done",
+		"{ return -x!; }",
+		"a ~ b",
+		"a ?: b",
+	}
+	for _, input := range inputs {
+		expected, expectedErr := lexValues(spec, input)
+		actual, err := lexValues(compiled, input)
+		if actual != expected || fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+			t.Fatalf("%v: expected %v, %v, got %v, %v", input, expected, expectedErr, actual, err)
+		}
+	}
+}
+
+func TestCompiledSpecificationIsImmutable(t *testing.T) {
+	spec := makeDocumentedLanguage()
+	compiled := spec.Compile()
+	if err := compiled.Extend(NewLanguage()); err == nil || err.Error() != "specificationerror: cannot extend a compiled specification" {
+		t.Fatalf("Expected an error extending a compiled specification, got %v", err)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != "specificationerror: cannot define * in a compiled specification" {
+			t.Fatalf("Expected a panic, got %v", recovered)
+		}
+	}()
+	spec.DefineInfix("*", 35)
+	if compiled.IsDefined("*") {
+		t.Fatalf("Expected the compiled specification to be unaffected by the original")
+	}
+	compiled.DefineInfix("*", 35)
+}
+
+func TestCompiledSpecificationsCanBeExtended(t *testing.T) {
+	dialect := makeDocumentedLanguage().Compile().Clone()
+	dialect.DefineInfix("*", 35)
+	extended := NewLanguage()
+	if err := extended.Extend(dialect.Compile()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !extended.IsDefined("*") || !extended.IsDefined("return") {
+		t.Fatalf("Expected the definitions of the compiled specification")
+	}
+}
+
+func TestCompiledSpecificationIsSafeForConcurrentUse(t *testing.T) {
+	compiled := makeDocumentedLanguage().Compile()
+	source := "{ return f(a, [b, c]).d + -e!; x = y ? \"z\" : true; }"
+	expected, err := parseShapes(compiled, source)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	var wait sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for j := 0; j < 50; j++ {
+				shapes, err := parseShapes(compiled, source)
+				if err != nil {
+					results[i] = err.Error()
+					return
+				}
+				results[i] = shapes
+			}
+		}(i)
+	}
+	wait.Wait()
+	for _, result := range results {
+		if result != expected {
+			t.Fatalf("Expected %v, got %v", expected, result)
+		}
+	}
+}
+
+func lexAll(b *testing.B, spec LanguageSpecification, source string) {
+	lexer := NewLexer(strings.NewReader(source), spec)
+	for {
+		token, err := lexer.Next()
+		if err != nil {
+			b.Fatalf("Unexpected error %v", err)
+		}
+		if token.Symbol == EOF {
+			return
+		}
+	}
+}
+
+func BenchmarkCompiled(b *testing.B) {
+	spec := makeDocumentedLanguage()
+	spec.DefineInfix("==", 50)
+	spec.DefineNumbers(NumberOptions{
+		HexPrefix:      true,
+		Exponents:      true,
+		DigitSeparator: '_',
+		Suffixes:       map[string]Symbol{"u": "(UINT)", "f32": "(FLOAT32)"},
+	})
+	source := strings.Repeat("{ total = total + 1_000u - 0x1F + 2.5e3f32 == f(count, [12345, 6.75f32]).next; }\n", 2000)
+	specs := map[string]LanguageSpecification{
+		"plain":    spec,
+		"compiled": spec.Compile(),
+	}
+	for _, name := range []string{"plain", "compiled"} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lexAll(b, specs[name], source)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package langkit
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+func (spec *languageSpecificationImpl) Clone() LanguageSpecification {
+	clone := &languageSpecificationImpl{
+		quoteDefinitions:     map[rune][]*quoteSpecification{},
+		commentDefinitions:   map[rune][]*commentSpecification{},
+		numberOptions:        copyNumberOptions(spec.numberOptions),
+		symbols:              map[Symbol]*Token{},
+		statementTerminators: append([]Symbol{}, spec.statementTerminators...),
+		blockDelimiters:      map[Symbol]Symbol{},
+		groupings:            map[Symbol]Symbol{},
+		newlineTerminator:    spec.newlineTerminator,
+		forms:                append([]Form{}, spec.forms...),
+		strict:               spec.strict,
+		conflicts:            append([]error{}, spec.conflicts...),
+	}
+	// Quote and comment specifications are never changed once
+	// defined, so the copies can share them
+	for start, quoteSpecs := range spec.quoteDefinitions {
+		clone.quoteDefinitions[start] = append([]*quoteSpecification{}, quoteSpecs...)
+	}
+	for start, commentSpecs := range spec.commentDefinitions {
+		clone.commentDefinitions[start] = append([]*commentSpecification{}, commentSpecs...)
+	}
+	for symbol, token := range spec.symbols {
+		clone.symbols[symbol] = copyDefinition(token)
+	}
+	for start, end := range spec.blockDelimiters {
+		clone.blockDelimiters[start] = end
+	}
+	for open, close := range spec.groupings {
+		clone.groupings[open] = close
+	}
+	return clone
+}
+
+func (spec *languageSpecificationImpl) Extend(base LanguageSpecification) error {
+	if compiled, ok := base.(*compiledSpecification); ok {
+		base = compiled.languageSpecificationImpl
+	}
+	other, ok := base.(*languageSpecificationImpl)
+	if !ok {
+		return fmt.Errorf("specificationerror: cannot extend a specification of type %T", base)
+	}
+	if other == spec {
+		return nil
+	}
+	conflicts := len(spec.conflicts)
+
+	symbols := make([]Symbol, 0, len(other.symbols))
+	for symbol := range other.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i] < symbols[j]
+	})
+	for _, symbol := range symbols {
+		token := other.symbols[symbol]
+		existing, found := spec.symbols[symbol]
+		if !found {
+			spec.symbols[symbol] = copyDefinition(token)
+			continue
+		}
+		// Both specifications declaring the same forms, as they do for
+		// names and numbers, merely defines the symbol twice over
+		if spec.strict && !spec.sharesForms(other, symbol) {
+			if conflict := definitionConflict(symbol, existing, token.Nud, token.Led, token.Std); conflict != nil {
+				spec.conflicts = append(spec.conflicts, conflict)
+				continue
+			}
+		}
+		mergeDefinition(existing, token.BindingPower, token.Nud, token.Led, token.Std)
+	}
+
+	for _, quoteSpecs := range other.quoteDefinitions {
+		for _, quoteSpec := range quoteSpecs {
+			spec.extendQuote(quoteSpec)
+		}
+	}
+	for _, commentSpecs := range other.commentDefinitions {
+		for _, commentSpec := range commentSpecs {
+			if !spec.hasComment(commentSpec.open) {
+				spec.defineComment(commentSpec)
+			}
+		}
+	}
+	if reflect.DeepEqual(*spec.numberOptions, NumberOptions{}) {
+		spec.numberOptions = copyNumberOptions(other.numberOptions)
+	}
+
+	for _, terminator := range other.statementTerminators {
+		if !spec.IsStatementTerminator(terminator) {
+			spec.statementTerminators = append(spec.statementTerminators, terminator)
+		}
+	}
+	if spec.newlineTerminator == "" {
+		spec.newlineTerminator = other.newlineTerminator
+	}
+	for start, end := range other.blockDelimiters {
+		if existing, found := spec.blockDelimiters[start]; !found {
+			spec.blockDelimiters[start] = end
+		} else if spec.strict && existing != end {
+			spec.conflicts = append(spec.conflicts, newSpecificationError(ConflictingDefinition, start, "block %v is already ended by %v", start, existing))
+		}
+	}
+	for open, close := range other.groupings {
+		if _, found := spec.groupings[open]; !found {
+			spec.groupings[open] = close
+		}
+	}
+
+	forms := []Form{}
+	for _, form := range other.forms {
+		if !spec.declares(form) {
+			forms = append(forms, form)
+		}
+	}
+	spec.forms = append(forms, spec.forms...)
+
+	if len(spec.conflicts) > conflicts {
+		return spec.conflicts[conflicts]
+	}
+	return nil
+}
+
+func (spec *languageSpecificationImpl) Remove(symbol Symbol) {
+	forms := []Form{}
+	helpers := []Symbol{}
+	for _, form := range spec.forms {
+		if form.Symbol != symbol && !(form.Kind == QuoteForm && form.Literal == symbol) {
+			forms = append(forms, form)
+		} else if form.Kind != QuoteForm && form.Kind != BlockCommentForm {
+			helpers = append(helpers, formHelpers(form)...)
+		}
+	}
+	spec.forms = forms
+
+	// A symbol another form still reads, such as the : of a ternary,
+	// stays defined without the functions of the removed forms
+	if token, found := spec.symbols[symbol]; found && spec.usedByForms(symbol) {
+		token.Nud, token.Led, token.Std = nil, nil, nil
+		token.BindingPower = 0
+	} else {
+		delete(spec.symbols, symbol)
+	}
+	for _, helper := range helpers {
+		token, found := spec.symbols[helper]
+		if found && helper != symbol && token.Nud == nil && token.Led == nil && token.Std == nil && !spec.usedByForms(helper) {
+			delete(spec.symbols, helper)
+		}
+	}
+
+	for start, quoteSpecs := range spec.quoteDefinitions {
+		kept := []*quoteSpecification{}
+		for _, quoteSpec := range quoteSpecs {
+			if quoteSpec.openQuote != string(symbol) && quoteSpec.literalType != symbol {
+				kept = append(kept, quoteSpec)
+			}
+		}
+		spec.quoteDefinitions[start] = kept
+	}
+	for start, commentSpecs := range spec.commentDefinitions {
+		kept := []*commentSpecification{}
+		for _, commentSpec := range commentSpecs {
+			if commentSpec.open != string(symbol) {
+				kept = append(kept, commentSpec)
+			}
+		}
+		spec.commentDefinitions[start] = kept
+	}
+
+	if end, found := spec.blockDelimiters[symbol]; found {
+		delete(spec.blockDelimiters, symbol)
+		// Blocks make their end a terminator, which only the remaining
+		// blocks ending the same way still need
+		if !spec.IsAnyBlockEnd(end) {
+			spec.removeTerminator(end)
+		}
+	}
+	if !spec.IsAnyBlockEnd(symbol) {
+		spec.removeTerminator(symbol)
+	}
+	if spec.newlineTerminator == symbol {
+		spec.newlineTerminator = ""
+	}
+	delete(spec.groupings, symbol)
+}
+
+// Lists the symbols other than its own that form defines for the
+// parser to expect, such as closing delimiters and separators
+func formHelpers(form Form) []Symbol {
+	helpers := []Symbol{}
+	for _, helper := range []Symbol{form.Close, form.Separator, form.Colon} {
+		if helper != "" {
+			helpers = append(helpers, helper)
+		}
+	}
+	if form.Kind == IndentedBlockForm {
+		helpers = append(helpers, Indent)
+	}
+	return helpers
+}
+
+// Checks whether any declared form defines symbol or expects it
+func (spec *languageSpecificationImpl) usedByForms(symbol Symbol) bool {
+	for _, form := range spec.forms {
+		if form.Symbol == symbol && form.Kind != QuoteForm && form.Kind != LineCommentForm && form.Kind != BlockCommentForm {
+			return true
+		}
+		if form.Kind == QuoteForm && form.Literal == symbol {
+			return true
+		}
+		if form.Kind == QuoteForm || form.Kind == BlockCommentForm {
+			continue
+		}
+		for _, helper := range formHelpers(form) {
+			if helper == symbol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (spec *languageSpecificationImpl) removeTerminator(symbol Symbol) {
+	terminators := []Symbol{}
+	for _, terminator := range spec.statementTerminators {
+		if terminator != symbol {
+			terminators = append(terminators, terminator)
+		}
+	}
+	spec.statementTerminators = terminators
+}
+
+// Adds a quote of another specification unless its opening
+// delimiter is already taken
+func (spec *languageSpecificationImpl) extendQuote(quoteSpec *quoteSpecification) {
+	start, _ := utf8.DecodeRuneInString(quoteSpec.openQuote)
+	for _, existing := range spec.quoteDefinitions[start] {
+		if existing.openQuote != quoteSpec.openQuote {
+			continue
+		}
+		if spec.strict && (existing.closeQuote != quoteSpec.closeQuote || existing.literalType != quoteSpec.literalType) {
+			spec.conflicts = append(spec.conflicts, newSpecificationError(ConflictingDefinition, Symbol(quoteSpec.openQuote), "quote %v is already defined", quoteSpec.openQuote))
+		}
+		return
+	}
+	quoteSpecs := append(spec.quoteDefinitions[start], quoteSpec)
+	sort.SliceStable(quoteSpecs, func(i, j int) bool {
+		return len(quoteSpecs[i].openQuote) > len(quoteSpecs[j].openQuote)
+	})
+	spec.quoteDefinitions[start] = quoteSpecs
+}
+
+func (spec *languageSpecificationImpl) hasComment(open string) bool {
+	start, _ := utf8.DecodeRuneInString(open)
+	for _, commentSpec := range spec.commentDefinitions[start] {
+		if commentSpec.open == open {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *languageSpecificationImpl) declares(form Form) bool {
+	for _, declared := range spec.forms {
+		if declared == form {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether every form other declares for symbol is also
+// declared here
+func (spec *languageSpecificationImpl) sharesForms(other *languageSpecificationImpl, symbol Symbol) bool {
+	for _, form := range other.forms {
+		if form.Symbol == symbol && !spec.declares(form) {
+			return false
+		}
+	}
+	return true
+}
+
+func copyDefinition(token *Token) *Token {
+	copied := *token
+	copied.Children = []*Token{}
+	return &copied
+}
+
+func copyNumberOptions(options *NumberOptions) *NumberOptions {
+	copied := *options
+	if options.Suffixes != nil {
+		copied.Suffixes = map[string]Symbol{}
+		for suffix, symbol := range options.Suffixes {
+			copied.Suffixes[suffix] = symbol
+		}
+	}
+	return &copied
+}
@@ -0,0 +1,130 @@
+package langkit
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseShapes(spec LanguageSpecification, source string) (string, error) {
+	parser := NewParser(NewLexer(strings.NewReader(source), spec))
+	statements, err := parser.Statements()
+	if err != nil {
+		return "", err
+	}
+	shapes := []string{}
+	for _, statement := range statements {
+		shapes = append(shapes, treeShape(statement))
+	}
+	return strings.Join(shapes, " "), nil
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	base := makeDocumentedLanguage()
+	forms := len(base.Forms())
+
+	dialect := base.Clone()
+	dialect.Remove("=")
+	dialect.Remove("return")
+	dialect.DefineInfix("*", 35)
+
+	if _, err := parseShapes(dialect, "a = b;"); err == nil {
+		t.Fatalf("Expected = to be removed from the dialect")
+	}
+	if _, err := parseShapes(base, "a * b;"); err == nil {
+		t.Fatalf("Expected * to be undefined in the base language")
+	}
+	shapes, err := parseShapes(base, "{ return; a = b + c; }")
+	if err != nil || shapes != "({ return (= a (+ b c)))" {
+		t.Fatalf("Expected the base language to be unchanged, got %v, %v", shapes, err)
+	}
+	shapes, err = parseShapes(dialect, "{ f(a) * -b!; }")
+	if err != nil || shapes != "({ (* (( f a) (- (! b))))" {
+		t.Fatalf("Unexpected parse in the dialect %v, %v", shapes, err)
+	}
+	if len(base.Forms()) != forms {
+		t.Fatalf("Expected the base language to keep its %v forms, got %v", forms, len(base.Forms()))
+	}
+}
+
+func TestExtend(t *testing.T) {
+	base := makeDocumentedLanguage()
+	dialect := NewLanguage()
+	dialect.SetStrict(true)
+	dialect.DefineInfix("*", 35)
+	if err := dialect.Extend(base); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	shapes, err := parseShapes(dialect, "a = \"b\" * c + d;")
+	if err != nil || shapes != "(= a (+ (* b c) d))" {
+		t.Fatalf("Unexpected parse %v, %v", shapes, err)
+	}
+	if base.IsDefined("*") {
+		t.Fatalf("Expected the base language to be unchanged")
+	}
+	if forms := dialect.Forms(); forms[len(forms)-1].Symbol != "*" || len(forms) != len(base.Forms())+1 {
+		t.Fatalf("Expected the forms of base before those of the dialect, got %v", forms)
+	}
+
+	conflicting := NewLanguage()
+	conflicting.SetStrict(true)
+	conflicting.DefineInfix("+", 35)
+	err = conflicting.Extend(base)
+	if err == nil || err.Error() != "specificationerror: + already has a led" {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	spec := makeDocumentedLanguage()
+	spec.DefineLineComment("#")
+	spec.DefineNewlineTerminator(";")
+	spec.Remove("{")
+	spec.Remove(";")
+	spec.Remove("#")
+	spec.Remove(StringLiteral)
+
+	if spec.IsDefined("{") || spec.IsBlockStart("{") || spec.IsStatementTerminator("}") {
+		t.Fatalf("Expected the block to be removed")
+	}
+	if spec.IsStatementTerminator(";") {
+		t.Fatalf("Expected ; to no longer end statements")
+	}
+	if _, found := spec.GetNewlineTerminator(); found {
+		t.Fatalf("Expected newlines to no longer end statements")
+	}
+	if len(spec.GetCommentSpecs('#')) != 0 || len(spec.GetQuoteSpecs('"')) != 0 {
+		t.Fatalf("Expected the comment and quotes to be removed")
+	}
+	for _, form := range spec.Forms() {
+		if form.Symbol == "{" || form.Symbol == ";" || form.Kind == QuoteForm {
+			t.Fatalf("Expected no forms of removed symbols, got %v", form)
+		}
+	}
+}
+
+func TestRemoveKeepsSymbolsOtherFormsUse(t *testing.T) {
+	spec := makeDocumentedLanguage()
+	spec.DefineInfix(":", 25)
+	spec.Remove(":")
+
+	if _, err := parseShapes(spec, "a : b;"); err == nil {
+		t.Fatalf("Expected the infix : to be removed")
+	}
+	shapes, err := parseShapes(spec, "a ? b : c;")
+	if err != nil || shapes != "(? a b c)" {
+		t.Fatalf("Expected the ternary to keep its :, got %v, %v", shapes, err)
+	}
+
+	spec.Remove("?")
+	spec.Remove("(")
+	if spec.IsDefined(":") || spec.IsDefined(")") {
+		t.Fatalf("Expected symbols no remaining form uses to be removed")
+	}
+	if !spec.IsDefined(",") {
+		t.Fatalf("Expected the separator of the list literal to be kept")
+	}
+	shapes, err = parseShapes(spec, "[a, b];")
+	if err != nil || shapes != "([ a b)" {
+		t.Fatalf("Unexpected parse after removal %v, %v", shapes, err)
+	}
+}
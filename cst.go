@@ -0,0 +1,44 @@
+package langkit
+
+import (
+	"sort"
+	"strings"
+)
+
+// Reproduces the source text of the given nodes, with their trivia
+// and punctuation, when they were parsed from a lexer retaining
+// trivia. Tokens are written in the order they appeared in the
+// source, so a program parsed with Program reproduces its input
+// exactly, and edits to the Raw text or trivia of tokens carry over.
+func Source(nodes ...*Token) string {
+	tokens := []*Token{}
+	for _, node := range nodes {
+		tokens = collectTokens(node, tokens)
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].rawOffset < tokens[j].rawOffset
+	})
+	var builder strings.Builder
+	for _, token := range tokens {
+		builder.WriteString(token.LeadingTrivia)
+		builder.WriteString(token.Raw)
+		builder.WriteString(token.TrailingTrivia)
+	}
+	return builder.String()
+}
+
+func collectTokens(node *Token, tokens []*Token) []*Token {
+	if node == nil {
+		return tokens
+	}
+	if node.Raw != "" || node.LeadingTrivia != "" {
+		tokens = append(tokens, node)
+	}
+	for _, child := range node.Children {
+		tokens = collectTokens(child, tokens)
+	}
+	for _, punctuation := range node.Punctuation {
+		tokens = collectTokens(punctuation, tokens)
+	}
+	return tokens
+}
@@ -0,0 +1,124 @@
+package langkit
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseLossless(t *testing.T, parser *TDOPParser) *Token {
+	parser.Lexer.(*TDOPLexer).RetainTrivia()
+	program, err := parser.Program()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return program
+}
+
+func TestSourceReproducesInput(t *testing.T) {
+	inputs := []string{
+		"",
+		"  // only a comment\n",
+		"{ return f( a ,b, ) ; }\n\n",
+		"x = (\"a  b\" + [1 ,2.5 , ]) ;;  /* done */",
+		"a.b(c)  ! ;\r\n  -d ? e : f;",
+		"naïve = \"ünïcode\"; // trailing\n\n// last\n",
+	}
+	for _, input := range inputs {
+		spec := makeDocumentedLanguage()
+		spec.DefineInfix("=", 10)
+		spec.DefineLineComment("//")
+		spec.DefineBlockComment("/*", "*/", false)
+		spec.DefineNumbers(NumberOptions{})
+		program := parseLossless(t, NewParser(NewLexer(strings.NewReader(input), spec)))
+		if actual := Source(program); actual != input {
+			t.Fatalf("Expected %q, got %q", input, actual)
+		}
+	}
+
+	newlineInputs := []string{
+		"A\nB",
+		"if { A } \n\n f(\n  x, // first\n  y,\n)\n",
+		"[A,\n B]++ + \"s\"\n/* end */",
+	}
+	for _, input := range newlineInputs {
+		program := parseLossless(t, NewParser(makeNewlineLexer(input)))
+		if actual := Source(program); actual != input {
+			t.Fatalf("Expected %q, got %q", input, actual)
+		}
+	}
+
+	indented := "x = 1\nif x:\n    y = 2\n    if y:\n        z = 3\n\n  # comment\n    w = (4 +\n  5)\nv = 6\nif v:\n\tu = 7"
+	program := parseLossless(t, makeIndentedParser(indented))
+	if actual := Source(program); actual != indented {
+		t.Fatalf("Expected %q, got %q", indented, actual)
+	}
+}
+
+func TestTokensKeepTriviaAndPunctuation(t *testing.T) {
+	source := "f(a, (b)) ; // call\n{ g }\n"
+	program := parseLossless(t, NewParser(NewLexer(strings.NewReader(source), makeDocumentedLanguageWithComments())))
+	if len(program.Children) != 2 {
+		t.Fatalf("Expected two statements, got %v", len(program.Children))
+	}
+
+	call := program.Children[0]
+	punctuation := []string{}
+	for _, token := range call.Punctuation {
+		punctuation = append(punctuation, token.Raw)
+	}
+	if strings.Join(punctuation, " ") != ", ) ;" {
+		t.Fatalf("Expected the call to keep its punctuation, got %v", punctuation)
+	}
+	semicolon := call.Punctuation[2]
+	if semicolon.LeadingTrivia != " " || semicolon.TrailingTrivia != " // call\n" {
+		t.Fatalf("Unexpected trivia %q and %q", semicolon.LeadingTrivia, semicolon.TrailingTrivia)
+	}
+	inner := call.Children[2]
+	if inner.Raw != "b" || len(inner.Punctuation) != 2 || inner.Punctuation[0].Raw != "(" || inner.Punctuation[1].Raw != ")" {
+		t.Fatalf("Expected the parenthesized name to keep its parentheses, got %v", inner.Punctuation)
+	}
+
+	block := program.Children[1]
+	if len(block.Punctuation) != 1 || block.Punctuation[0].Raw != "}" || block.Punctuation[0].TrailingTrivia != "\n" {
+		t.Fatalf("Expected the block to keep its closing brace, got %v", block.Punctuation)
+	}
+	if eof := program.Punctuation[len(program.Punctuation)-1]; eof.Symbol != EOF {
+		t.Fatalf("Expected the program to end with EOF, got %v", eof.Symbol)
+	}
+
+	// Renaming a token carries over to the printed source
+	call.Children[0].Raw = "print"
+	if actual := Source(program); actual != "print(a, (b)) ; // call\n{ g }\n" {
+		t.Fatalf("Unexpected source %q", actual)
+	}
+}
+
+func TestSourceAfterBacktracking(t *testing.T) {
+	spec := makeDocumentedLanguageWithComments()
+	spec.DefineStatment("try", func(token *Token, parser *TDOPParser) (*Token, error) {
+		mark := parser.Lexer.Mark()
+		for i := 0; i < 3; i++ {
+			if _, err := parser.Lexer.Next(); err != nil {
+				return nil, err
+			}
+		}
+		parser.Lexer.Reset(mark)
+		expression, err := parser.Expression(0)
+		if err != nil {
+			return nil, err
+		}
+		token.Children = append(token.Children, expression)
+		return token, nil
+	})
+	source := "try (a + b) ;"
+	program := parseLossless(t, NewParser(NewLexer(strings.NewReader(source), spec)))
+	if actual := Source(program); actual != source {
+		t.Fatalf("Expected %q, got %q", source, actual)
+	}
+}
+
+func makeDocumentedLanguageWithComments() LanguageSpecification {
+	spec := makeDocumentedLanguage()
+	spec.DefineLineComment("//")
+	return spec
+}
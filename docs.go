@@ -0,0 +1,528 @@
+package langkit
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// Distinguishes the nodes of a grammar rule
+type GrammarNodeKind string
+
+const (
+	TerminalNode    GrammarNodeKind = "terminal"
+	NonTerminalNode GrammarNodeKind = "nonterminal"
+	// Syntax that cannot be described, such as the body of a statement
+	// parsed by a std function
+	SpecialNode  GrammarNodeKind = "special"
+	SequenceNode GrammarNodeKind = "sequence"
+	ChoiceNode   GrammarNodeKind = "choice"
+	OptionalNode GrammarNodeKind = "optional"
+	RepeatNode   GrammarNodeKind = "repeat"
+)
+
+// A node of a grammar rule. Terminals, non-terminals and special
+// nodes carry Text, while the other kinds combine their Children.
+type GrammarNode struct {
+	Kind     GrammarNodeKind
+	Text     string
+	Children []*GrammarNode
+}
+
+type GrammarRule struct {
+	Name       string
+	Definition *GrammarNode
+}
+
+func terminal(symbol Symbol) *GrammarNode {
+	return &GrammarNode{Kind: TerminalNode, Text: string(symbol)}
+}
+
+func nonTerminal(name string) *GrammarNode {
+	return &GrammarNode{Kind: NonTerminalNode, Text: name}
+}
+
+func special(text string) *GrammarNode {
+	return &GrammarNode{Kind: SpecialNode, Text: text}
+}
+
+func sequence(nodes ...*GrammarNode) *GrammarNode {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &GrammarNode{Kind: SequenceNode, Children: nodes}
+}
+
+func choice(nodes ...*GrammarNode) *GrammarNode {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &GrammarNode{Kind: ChoiceNode, Children: nodes}
+}
+
+func optional(node *GrammarNode) *GrammarNode {
+	return &GrammarNode{Kind: OptionalNode, Children: []*GrammarNode{node}}
+}
+
+func repeat(node *GrammarNode) *GrammarNode {
+	return &GrammarNode{Kind: RepeatNode, Children: []*GrammarNode{node}}
+}
+
+// Names the symbols generated by the lexer, such as (NAME), and
+// otherwise treats symbols as literal text
+func symbolNode(symbol Symbol) *GrammarNode {
+	text := string(symbol)
+	if len(text) > 2 && strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		return nonTerminal(strings.ToLower(text[1 : len(text)-1]))
+	}
+	return terminal(symbol)
+}
+
+// A comma separated list of items with an optional trailing separator
+func delimitedList(open Symbol, item *GrammarNode, separator Symbol, close Symbol) *GrammarNode {
+	items := sequence(item, repeat(sequence(terminal(separator), item)), optional(terminal(separator)))
+	return sequence(terminal(open), optional(items), terminal(close))
+}
+
+// Operators sharing a binding power
+type precedenceLevel struct {
+	bindingPower int
+	forms        []Form
+}
+
+func precedenceLevels(spec LanguageSpecification) []*precedenceLevel {
+	levels := map[int]*precedenceLevel{}
+	for _, form := range spec.Forms() {
+		switch form.Kind {
+		case PrefixForm, InfixForm, PostfixForm, TernaryForm:
+			level, found := levels[form.BindingPower]
+			if !found {
+				level = &precedenceLevel{bindingPower: form.BindingPower}
+				levels[form.BindingPower] = level
+			}
+			level.forms = append(level.forms, form)
+		}
+	}
+	sorted := []*precedenceLevel{}
+	for _, level := range levels {
+		sorted = append(sorted, level)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].bindingPower < sorted[j].bindingPower
+	})
+	return sorted
+}
+
+// Derives approximate grammar rules from the constructs declared in
+// the specification, starting from a program of statements
+func GrammarRules(spec LanguageSpecification) []GrammarRule {
+	forms := spec.Forms()
+	formsOf := func(kinds ...FormKind) []Form {
+		matching := []Form{}
+		for _, form := range forms {
+			for _, kind := range kinds {
+				if form.Kind == kind {
+					matching = append(matching, form)
+				}
+			}
+		}
+		return matching
+	}
+	rules := []GrammarRule{{Name: "program", Definition: repeat(nonTerminal("statement"))}}
+
+	// Statements
+	blocks := formsOf(BlockForm, IndentedBlockForm)
+	// Symbols given a std through Define rather than DefineStatment
+	// are statements too
+	declared := map[Symbol]bool{}
+	for _, form := range blocks {
+		declared[form.Symbol] = true
+	}
+	statements := []*GrammarNode{}
+	for _, info := range spec.Symbols() {
+		if info.HasStd && !declared[info.Symbol] {
+			statements = append(statements, sequence(terminal(info.Symbol), special(fmt.Sprintf("%v statement", info.Symbol))))
+		}
+	}
+	if len(blocks) > 0 {
+		statements = append(statements, nonTerminal("block"))
+	}
+	terminators := []*GrammarNode{}
+	for _, form := range formsOf(TerminatorForm) {
+		if !spec.IsAnyBlockEnd(form.Symbol) {
+			terminators = append(terminators, terminal(form.Symbol))
+		}
+	}
+	if len(formsOf(NewlineTerminatorForm)) > 0 {
+		terminators = append(terminators, special("newline"))
+	}
+	if len(terminators) > 0 {
+		statements = append(statements, sequence(nonTerminal("expression"), nonTerminal("terminator")))
+	} else {
+		statements = append(statements, nonTerminal("expression"))
+	}
+	rules = append(rules, GrammarRule{Name: "statement", Definition: choice(statements...)})
+	if len(terminators) > 0 {
+		rules = append(rules, GrammarRule{Name: "terminator", Definition: choice(terminators...)})
+	}
+	if len(blocks) > 0 {
+		alternatives := []*GrammarNode{}
+		for _, block := range blocks {
+			if block.Kind == IndentedBlockForm {
+				alternatives = append(alternatives, sequence(terminal(block.Symbol), nonTerminal("indent"), repeat(nonTerminal("statement")), nonTerminal("dedent")))
+			} else {
+				alternatives = append(alternatives, sequence(terminal(block.Symbol), repeat(nonTerminal("statement")), terminal(block.Close)))
+			}
+		}
+		rules = append(rules, GrammarRule{Name: "block", Definition: choice(alternatives...)})
+	}
+
+	// Operators, from the loosest binding to the tightest
+	accesses := formsOf(CallForm, IndexForm, MemberAccessForm)
+	innermost := "primary"
+	if len(accesses) > 0 {
+		innermost = "access"
+	}
+	levels := precedenceLevels(spec)
+	levelName := func(i int) string {
+		if i >= len(levels) {
+			return innermost
+		}
+		return fmt.Sprintf("precedence_%v", levels[i].bindingPower)
+	}
+	rules = append(rules, GrammarRule{Name: "expression", Definition: nonTerminal(levelName(0))})
+	for i, level := range levels {
+		self := nonTerminal(levelName(i))
+		next := nonTerminal(levelName(i + 1))
+		prefixes := []*GrammarNode{}
+		leftOperators := []*GrammarNode{}
+		rightOperators := []*GrammarNode{}
+		repeated := []*GrammarNode{}
+		trailing := []*GrammarNode{}
+		for _, form := range level.forms {
+			switch {
+			case form.Kind == PrefixForm:
+				prefixes = append(prefixes, terminal(form.Symbol))
+			case form.Kind == PostfixForm:
+				repeated = append(repeated, terminal(form.Symbol))
+			case form.Kind == TernaryForm:
+				trailing = append(trailing, sequence(terminal(form.Symbol), nonTerminal("expression"), terminal(form.Colon), self))
+			case form.RightAssociative:
+				rightOperators = append(rightOperators, terminal(form.Symbol))
+			default:
+				leftOperators = append(leftOperators, terminal(form.Symbol))
+			}
+		}
+		if len(leftOperators) > 0 {
+			repeated = append([]*GrammarNode{sequence(choice(leftOperators...), next)}, repeated...)
+		}
+		if len(rightOperators) > 0 {
+			trailing = append([]*GrammarNode{sequence(choice(rightOperators...), self)}, trailing...)
+		}
+		operand := []*GrammarNode{next}
+		if len(repeated) > 0 {
+			operand = append(operand, repeat(choice(repeated...)))
+		}
+		if len(trailing) > 0 {
+			operand = append(operand, optional(choice(trailing...)))
+		}
+		definition := sequence(operand...)
+		if len(prefixes) > 0 {
+			definition = choice(sequence(choice(prefixes...), self), definition)
+		}
+		rules = append(rules, GrammarRule{Name: levelName(i), Definition: definition})
+	}
+	if len(accesses) > 0 {
+		alternatives := []*GrammarNode{}
+		for _, form := range accesses {
+			switch form.Kind {
+			case CallForm:
+				alternatives = append(alternatives, delimitedList(form.Symbol, nonTerminal("expression"), form.Separator, form.Close))
+			case IndexForm:
+				alternatives = append(alternatives, sequence(terminal(form.Symbol), nonTerminal("expression"), terminal(form.Close)))
+			case MemberAccessForm:
+				alternatives = append(alternatives, sequence(terminal(form.Symbol), nonTerminal("name")))
+			}
+		}
+		rules = append(rules, GrammarRule{Name: "access", Definition: sequence(nonTerminal("primary"), repeat(choice(alternatives...)))})
+	}
+
+	// Primary expressions
+	primaries := []*GrammarNode{}
+	seen := map[Symbol]bool{}
+	for _, form := range forms {
+		switch form.Kind {
+		case ValueForm:
+			if !seen[form.Symbol] {
+				seen[form.Symbol] = true
+				primaries = append(primaries, symbolNode(form.Symbol))
+			}
+		case QuoteForm:
+			if !seen[form.Literal] {
+				seen[form.Literal] = true
+				primaries = append(primaries, symbolNode(form.Literal))
+			}
+		}
+	}
+	for _, form := range formsOf(ParensForm) {
+		primaries = append(primaries, sequence(terminal(form.Symbol), nonTerminal("expression"), terminal(form.Close)))
+	}
+	lists := formsOf(ListForm)
+	maps := formsOf(MapForm)
+	if len(lists) > 0 {
+		primaries = append(primaries, nonTerminal("list"))
+	}
+	if len(maps) > 0 {
+		primaries = append(primaries, nonTerminal("map"))
+	}
+	rules = append(rules, GrammarRule{Name: "primary", Definition: choice(primaries...)})
+	if len(lists) > 0 {
+		alternatives := []*GrammarNode{}
+		for _, form := range lists {
+			alternatives = append(alternatives, delimitedList(form.Symbol, nonTerminal("expression"), form.Separator, form.Close))
+		}
+		rules = append(rules, GrammarRule{Name: "list", Definition: choice(alternatives...)})
+	}
+	if len(maps) > 0 {
+		alternatives := []*GrammarNode{}
+		for _, form := range maps {
+			entry := sequence(nonTerminal("expression"), terminal(form.Colon), nonTerminal("expression"))
+			alternatives = append(alternatives, delimitedList(form.Symbol, entry, form.Separator, form.Close))
+		}
+		rules = append(rules, GrammarRule{Name: "map", Definition: choice(alternatives...)})
+	}
+	return rules
+}
+
+// Renders the operators of a language as a markdown table, from the
+// most tightly binding to the least
+func PrecedenceTable(spec LanguageSpecification) string {
+	var builder strings.Builder
+	builder.WriteString("| Binding power | Operator | Kind | Associativity |\n")
+	builder.WriteString("|---|---|---|---|\n")
+	levels := precedenceLevels(spec)
+	accesses := []Form{}
+	for _, form := range spec.Forms() {
+		switch form.Kind {
+		case CallForm, IndexForm, MemberAccessForm:
+			accesses = append(accesses, form)
+		}
+	}
+	if len(accesses) > 0 {
+		levels = append(levels, &precedenceLevel{bindingPower: AccessBindingPower, forms: accesses})
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, form := range levels[i].forms {
+			operator := string(form.Symbol)
+			associativity := "left"
+			switch form.Kind {
+			case PrefixForm:
+				associativity = "right"
+			case PostfixForm:
+				associativity = "left"
+			case TernaryForm:
+				operator = fmt.Sprintf("%v %v", form.Symbol, form.Colon)
+			case CallForm, IndexForm:
+				operator = fmt.Sprintf("%v %v", form.Symbol, form.Close)
+			}
+			if form.RightAssociative {
+				associativity = "right"
+			}
+			builder.WriteString(fmt.Sprintf("| %v | `%v` | %v | %v |\n", levels[i].bindingPower, strings.ReplaceAll(operator, "|", "\\|"), form.Kind, associativity))
+		}
+	}
+	return builder.String()
+}
+
+// Renders the grammar rules of a language as EBNF
+func EBNF(spec LanguageSpecification) string {
+	var builder strings.Builder
+	for _, rule := range GrammarRules(spec) {
+		builder.WriteString(fmt.Sprintf("%v = %v ;\n", rule.Name, ebnf(rule.Definition, false)))
+	}
+	return builder.String()
+}
+
+func ebnf(node *GrammarNode, nested bool) string {
+	switch node.Kind {
+	case TerminalNode:
+		if strings.Contains(node.Text, "\"") {
+			return "'" + node.Text + "'"
+		}
+		return "\"" + node.Text + "\""
+	case NonTerminalNode:
+		return node.Text
+	case SpecialNode:
+		return "? " + node.Text + " ?"
+	case SequenceNode:
+		parts := []string{}
+		for _, child := range node.Children {
+			parts = append(parts, ebnf(child, true))
+		}
+		return strings.Join(parts, " ")
+	case ChoiceNode:
+		parts := []string{}
+		for _, child := range node.Children {
+			parts = append(parts, ebnf(child, false))
+		}
+		if nested {
+			return "( " + strings.Join(parts, " | ") + " )"
+		}
+		return strings.Join(parts, " | ")
+	case OptionalNode:
+		return "[ " + ebnf(node.Children[0], false) + " ]"
+	case RepeatNode:
+		return "{ " + ebnf(node.Children[0], false) + " }"
+	}
+	return ""
+}
+
+// Railroad diagram layout, in pixels
+const (
+	railCharWidth  = 8
+	railBoxHeight  = 24
+	railGap        = 16
+	railIndent     = 20
+	railRuleHeader = 28
+)
+
+// The size of a laid out node, where baseline is the height at which
+// the track enters and leaves it
+type railBox struct {
+	width    int
+	height   int
+	baseline int
+}
+
+func railLayout(node *GrammarNode) railBox {
+	switch node.Kind {
+	case TerminalNode, NonTerminalNode, SpecialNode:
+		return railBox{width: len([]rune(node.Text))*railCharWidth + 20, height: railBoxHeight, baseline: railBoxHeight / 2}
+	case SequenceNode:
+		box := railBox{}
+		below := 0
+		for i, child := range node.Children {
+			childBox := railLayout(child)
+			if i > 0 {
+				box.width += railGap
+			}
+			box.width += childBox.width
+			if childBox.baseline > box.baseline {
+				box.baseline = childBox.baseline
+			}
+			if childBox.height-childBox.baseline > below {
+				below = childBox.height - childBox.baseline
+			}
+		}
+		box.height = box.baseline + below
+		return box
+	case ChoiceNode:
+		box := railBox{}
+		for i, child := range node.Children {
+			childBox := railLayout(child)
+			if i == 0 {
+				box.baseline = childBox.baseline
+			} else {
+				box.height += railGap / 2
+			}
+			box.height += childBox.height
+			if childBox.width+2*railIndent > box.width {
+				box.width = childBox.width + 2*railIndent
+			}
+		}
+		return box
+	case OptionalNode, RepeatNode:
+		childBox := railLayout(node.Children[0])
+		box := railBox{width: childBox.width + 2*railIndent, height: railGap + childBox.height, baseline: railGap / 2}
+		if node.Kind == RepeatNode {
+			box.height += railGap / 2
+		}
+		return box
+	}
+	return railBox{}
+}
+
+func railLine(builder *strings.Builder, points ...int) {
+	coordinates := []string{}
+	for i := 0; i+1 < len(points); i += 2 {
+		coordinates = append(coordinates, fmt.Sprintf("%v,%v", points[i], points[i+1]))
+	}
+	builder.WriteString(fmt.Sprintf("<polyline class=\"track\" points=\"%v\"/>\n", strings.Join(coordinates, " ")))
+}
+
+// Draws the node with its top left corner at x, y
+func railDraw(builder *strings.Builder, node *GrammarNode, x int, y int) {
+	box := railLayout(node)
+	switch node.Kind {
+	case TerminalNode, NonTerminalNode, SpecialNode:
+		builder.WriteString(fmt.Sprintf("<rect class=\"%v\" x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\"", node.Kind, x, y, box.width, box.height))
+		if node.Kind == TerminalNode {
+			builder.WriteString(" rx=\"10\"")
+		}
+		builder.WriteString("/>\n")
+		builder.WriteString(fmt.Sprintf("<text x=\"%v\" y=\"%v\">%v</text>\n", x+box.width/2, y+box.baseline+4, html.EscapeString(node.Text)))
+	case SequenceNode:
+		for i, child := range node.Children {
+			childBox := railLayout(child)
+			if i > 0 {
+				railLine(builder, x-railGap, y+box.baseline, x, y+box.baseline)
+			}
+			railDraw(builder, child, x, y+box.baseline-childBox.baseline)
+			x += childBox.width + railGap
+		}
+	case ChoiceNode:
+		top := y
+		for _, child := range node.Children {
+			childBox := railLayout(child)
+			childBaseline := top + childBox.baseline
+			railLine(builder, x, y+box.baseline, x+railIndent/2, y+box.baseline, x+railIndent/2, childBaseline, x+railIndent, childBaseline)
+			railDraw(builder, child, x+railIndent, top)
+			right := x + box.width
+			railLine(builder, x+railIndent+childBox.width, childBaseline, right-railIndent/2, childBaseline, right-railIndent/2, y+box.baseline, right, y+box.baseline)
+			top += childBox.height + railGap/2
+		}
+	case OptionalNode, RepeatNode:
+		child := node.Children[0]
+		childBox := railLayout(child)
+		childTop := y + railGap
+		childBaseline := childTop + childBox.baseline
+		right := x + box.width
+		// The bypass, followed by the path through the child
+		railLine(builder, x, y+box.baseline, right, y+box.baseline)
+		railLine(builder, x+railIndent/2, y+box.baseline, x+railIndent/2, childBaseline, x+railIndent, childBaseline)
+		railDraw(builder, child, x+railIndent, childTop)
+		railLine(builder, right-railIndent, childBaseline, right-railIndent/2, childBaseline, right-railIndent/2, y+box.baseline)
+		if node.Kind == RepeatNode {
+			loop := childTop + childBox.height + railGap/4
+			railLine(builder, right-railIndent, childBaseline, right-railIndent, loop, x+railIndent, loop, x+railIndent, childBaseline)
+		}
+	}
+}
+
+// Renders the grammar rules of a language as SVG railroad diagrams,
+// one beneath another
+func RailroadSVG(spec LanguageSpecification) string {
+	var body strings.Builder
+	width := 0
+	y := 0
+	for _, rule := range GrammarRules(spec) {
+		box := railLayout(rule.Definition)
+		body.WriteString(fmt.Sprintf("<text class=\"rule\" x=\"10\" y=\"%v\">%v</text>\n", y+18, html.EscapeString(rule.Name)))
+		y += railRuleHeader
+		baseline := y + box.baseline
+		railLine(&body, 10, baseline, 30, baseline)
+		railDraw(&body, rule.Definition, 30, y)
+		railLine(&body, 30+box.width, baseline, 50+box.width, baseline)
+		if 60+box.width > width {
+			width = 60 + box.width
+		}
+		y += box.height + railGap
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\">\n", width, y))
+	builder.WriteString("<style>.track{fill:none;stroke:#333;stroke-width:2} rect{fill:#fff;stroke:#333;stroke-width:2} rect.special{stroke-dasharray:4} text{font:13px monospace;text-anchor:middle} text.rule{font-weight:bold;text-anchor:start}</style>\n")
+	builder.WriteString(body.String())
+	builder.WriteString("</svg>\n")
+	return builder.String()
+}
@@ -0,0 +1,122 @@
+package langkit
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func makeDocumentedLanguage() LanguageSpecification {
+	spec := NewLanguage()
+	spec.DefineQuotes('"', '"', StringLiteral)
+	spec.DefineValue("true")
+	spec.DefineInfixRight("=", 10)
+	spec.DefineTernary("?", ":", 20)
+	spec.DefineInfix("+", 30)
+	spec.DefineInfix("-", 30)
+	spec.DefinePrefix("-", 40)
+	spec.DefinePostfix("!", 50)
+	spec.DefineParens("(", ")")
+	spec.DefineCall("(", ")", ",")
+	spec.DefineMemberAccess(".")
+	spec.DefineListLiteral("[", "]", ",")
+	spec.DefineStatementTerminator(";")
+	spec.DefineBlock("{", "}")
+	spec.DefineStatment("return", func(t *Token, p *TDOPParser) (*Token, error) { return t, nil })
+	return spec
+}
+
+func TestSymbolsAndForms(t *testing.T) {
+	spec := makeDocumentedLanguage()
+	symbols := map[Symbol]SymbolInfo{}
+	for _, info := range spec.Symbols() {
+		symbols[info.Symbol] = info
+	}
+	minus := symbols["-"]
+	if !minus.HasNud || !minus.HasLed || minus.HasStd || minus.BindingPower != 40 {
+		t.Fatalf("Unexpected description of -: %+v", minus)
+	}
+	if call := symbols["("]; call.BindingPower != AccessBindingPower || !call.HasNud || !call.HasLed {
+		t.Fatalf("Unexpected description of (: %+v", call)
+	}
+	if block := symbols["{"]; !block.HasStd || block.HasNud {
+		t.Fatalf("Unexpected description of {: %+v", block)
+	}
+
+	kinds := []string{}
+	for _, form := range spec.Forms() {
+		if form.Symbol == "=" && !form.RightAssociative {
+			t.Fatalf("Expected = to be right-associative")
+		}
+		kinds = append(kinds, string(form.Kind))
+	}
+	expected := "value value value quote value value infix ternary infix infix prefix postfix parens call memberaccess list terminator block statement"
+	if strings.Join(kinds, " ") != expected {
+		t.Fatalf("Expected forms %v, got %v", expected, strings.Join(kinds, " "))
+	}
+}
+
+func TestEBNF(t *testing.T) {
+	expected := `program = { statement } ;
+statement = "return" ? return statement ? | block | expression terminator ;
+terminator = ";" ;
+block = "{" { statement } "}" ;
+expression = precedence_10 ;
+precedence_10 = precedence_20 [ "=" precedence_10 ] ;
+precedence_20 = precedence_30 [ "?" expression ":" precedence_20 ] ;
+precedence_30 = precedence_40 { ( "+" | "-" ) precedence_40 } ;
+precedence_40 = "-" precedence_40 | precedence_50 ;
+precedence_50 = access { "!" } ;
+access = primary { "(" [ expression { "," expression } [ "," ] ] ")" | "." name } ;
+primary = name | int | float | string | "true" | "(" expression ")" | list ;
+list = "[" [ expression { "," expression } [ "," ] ] "]" ;
+`
+	actual := EBNF(makeDocumentedLanguage())
+	if actual != expected {
+		t.Fatalf("Expected\n%v\ngot\n%v", expected, actual)
+	}
+}
+
+func TestPrecedenceTable(t *testing.T) {
+	table := PrecedenceTable(makeDocumentedLanguage())
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	expected := []string{
+		"| Binding power | Operator | Kind | Associativity |",
+		"|---|---|---|---|",
+		"| 1000 | `( )` | call | left |",
+		"| 1000 | `.` | memberaccess | left |",
+		"| 50 | `!` | postfix | left |",
+		"| 40 | `-` | prefix | right |",
+		"| 30 | `+` | infix | left |",
+		"| 30 | `-` | infix | left |",
+		"| 20 | `? :` | ternary | right |",
+		"| 10 | `=` | infix | right |",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%v\ngot\n%v", strings.Join(expected, "\n"), table)
+	}
+}
+
+func TestRailroadSVG(t *testing.T) {
+	svg := RailroadSVG(makeDocumentedLanguage())
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	texts := map[string]bool{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected well-formed SVG, got %v", err)
+		}
+		if data, ok := token.(xml.CharData); ok {
+			texts[string(data)] = true
+		}
+	}
+	for _, text := range []string{"program", "precedence_30", "access", "+", "return statement", "name"} {
+		if !texts[text] {
+			t.Fatalf("Expected the diagram to contain %q", text)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package langkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// A declarative description of a language's surface syntax, read
+// from JSON by LoadGrammar. Statements, whose parsing cannot be
+// described declaratively, name std functions supplied in Go.
+type Grammar struct {
+	Operators         []OperatorGrammar     `json:"operators"`
+	Values            []string              `json:"values"`
+	Statements        []string              `json:"statements"`
+	Quotes            []QuoteGrammar        `json:"quotes"`
+	Numbers           *NumberGrammar        `json:"numbers"`
+	LineComments      []string              `json:"lineComments"`
+	BlockComments     []BlockCommentGrammar `json:"blockComments"`
+	Terminators       []string              `json:"terminators"`
+	NewlineTerminator string                `json:"newlineTerminator"`
+	Blocks            []DelimiterGrammar    `json:"blocks"`
+	IndentedBlocks    []string              `json:"indentedBlocks"`
+	Parens            []DelimiterGrammar    `json:"parens"`
+	Calls             []DelimiterGrammar    `json:"calls"`
+	Indexes           []DelimiterGrammar    `json:"indexes"`
+	MemberAccess      []string              `json:"memberAccess"`
+	Lists             []DelimiterGrammar    `json:"lists"`
+	Maps              []DelimiterGrammar    `json:"maps"`
+	Ternaries         []TernaryGrammar      `json:"ternaries"`
+	// Rejects conflicting definitions and any other problem reported
+	// by Validate
+	Strict bool `json:"strict"`
+}
+
+type OperatorGrammar struct {
+	Symbol string `json:"symbol"`
+	// One of "infix", "prefix" or "postfix"
+	Kind       string `json:"kind"`
+	Precedence int    `json:"precedence"`
+	// Either "left", the default, or "right". Only infix operators
+	// may be right-associative.
+	Associativity string `json:"associativity"`
+}
+
+type QuoteGrammar struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+	// The symbol of the literals, StringLiteral by default
+	LiteralType string `json:"literalType"`
+	// Any of "c" and "unicode", or none for raw literals
+	Escapes   []string `json:"escapes"`
+	Multiline bool     `json:"multiline"`
+}
+
+type NumberGrammar struct {
+	Hex       bool              `json:"hex"`
+	Octal     bool              `json:"octal"`
+	Binary    bool              `json:"binary"`
+	Exponents bool              `json:"exponents"`
+	Separator string            `json:"separator"`
+	Suffixes  map[string]string `json:"suffixes"`
+}
+
+type BlockCommentGrammar struct {
+	Open     string `json:"open"`
+	Close    string `json:"close"`
+	Nestable bool   `json:"nestable"`
+}
+
+// Describes a delimited construct. Separator and Colon are only used
+// by the constructs that take them.
+type DelimiterGrammar struct {
+	Open      string `json:"open"`
+	Close     string `json:"close"`
+	Separator string `json:"separator"`
+	Colon     string `json:"colon"`
+}
+
+type TernaryGrammar struct {
+	Question   string `json:"question"`
+	Colon      string `json:"colon"`
+	Precedence int    `json:"precedence"`
+}
+
+// Reads a JSON grammar and builds the language it describes, taking
+// the std function of each statement keyword from statements
+func LoadGrammar(reader io.Reader, statements map[string]StdFunction) (LanguageSpecification, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	grammar := &Grammar{}
+	if err := decoder.Decode(grammar); err != nil {
+		return nil, fmt.Errorf("grammarerror: %v", err)
+	}
+	return grammar.Build(statements)
+}
+
+// Builds the language described by the grammar
+func (grammar *Grammar) Build(statements map[string]StdFunction) (LanguageSpecification, error) {
+	spec := NewLanguage()
+	spec.SetStrict(grammar.Strict)
+
+	for _, operator := range grammar.Operators {
+		symbol := Symbol(operator.Symbol)
+		if operator.Symbol == "" {
+			return nil, fmt.Errorf("grammarerror: operator without a symbol")
+		}
+		if operator.Associativity != "" && operator.Associativity != "left" && operator.Associativity != "right" {
+			return nil, fmt.Errorf("grammarerror: unknown associativity %q for operator %v", operator.Associativity, symbol)
+		}
+		rightAssociative := operator.Associativity == "right"
+		switch operator.Kind {
+		case "infix":
+			if rightAssociative {
+				spec.DefineInfixRight(symbol, operator.Precedence)
+			} else {
+				spec.DefineInfix(symbol, operator.Precedence)
+			}
+		case "prefix", "postfix":
+			if rightAssociative {
+				return nil, fmt.Errorf("grammarerror: %v operator %v cannot be right-associative", operator.Kind, symbol)
+			}
+			if operator.Kind == "prefix" {
+				spec.DefinePrefix(symbol, operator.Precedence)
+			} else {
+				spec.DefinePostfix(symbol, operator.Precedence)
+			}
+		default:
+			return nil, fmt.Errorf("grammarerror: unknown kind %q for operator %v", operator.Kind, symbol)
+		}
+	}
+
+	for _, value := range grammar.Values {
+		spec.DefineValue(Symbol(value))
+	}
+
+	for _, keyword := range grammar.Statements {
+		std, found := statements[keyword]
+		if !found {
+			return nil, fmt.Errorf("grammarerror: no std function supplied for statement %v", keyword)
+		}
+		spec.DefineStatment(Symbol(keyword), std)
+	}
+
+	for _, quote := range grammar.Quotes {
+		if quote.Open == "" || quote.Close == "" {
+			return nil, fmt.Errorf("grammarerror: quotes need both an open and a close delimiter")
+		}
+		literalType := StringLiteral
+		if quote.LiteralType != "" {
+			literalType = Symbol(quote.LiteralType)
+		}
+		escapes := NoEscapes
+		for _, escape := range quote.Escapes {
+			switch escape {
+			case "c":
+				escapes |= CEscapes
+			case "unicode":
+				escapes |= UnicodeEscapes
+			default:
+				return nil, fmt.Errorf("grammarerror: unknown escapes %q for quote %v", escape, quote.Open)
+			}
+		}
+		spec.DefineQuotesWithOptions(quote.Open, quote.Close, literalType, QuoteOptions{
+			Escapes:   escapes,
+			Multiline: quote.Multiline,
+		})
+	}
+
+	if grammar.Numbers != nil {
+		options := NumberOptions{
+			HexPrefix:    grammar.Numbers.Hex,
+			OctalPrefix:  grammar.Numbers.Octal,
+			BinaryPrefix: grammar.Numbers.Binary,
+			Exponents:    grammar.Numbers.Exponents,
+		}
+		if grammar.Numbers.Separator != "" {
+			separator, size := utf8.DecodeRuneInString(grammar.Numbers.Separator)
+			if size != len(grammar.Numbers.Separator) {
+				return nil, fmt.Errorf("grammarerror: digit separator %q is not a single character", grammar.Numbers.Separator)
+			}
+			options.DigitSeparator = separator
+		}
+		if len(grammar.Numbers.Suffixes) > 0 {
+			options.Suffixes = map[string]Symbol{}
+			for suffix, symbol := range grammar.Numbers.Suffixes {
+				options.Suffixes[suffix] = Symbol(symbol)
+			}
+		}
+		spec.DefineNumbers(options)
+	}
+
+	for _, prefix := range grammar.LineComments {
+		spec.DefineLineComment(prefix)
+	}
+	for _, comment := range grammar.BlockComments {
+		if comment.Open == "" || comment.Close == "" {
+			return nil, fmt.Errorf("grammarerror: block comments need both an open and a close delimiter")
+		}
+		spec.DefineBlockComment(comment.Open, comment.Close, comment.Nestable)
+	}
+
+	for _, terminator := range grammar.Terminators {
+		spec.DefineStatementTerminator(Symbol(terminator))
+	}
+	if grammar.NewlineTerminator != "" {
+		spec.DefineNewlineTerminator(Symbol(grammar.NewlineTerminator))
+	}
+
+	for _, block := range grammar.Blocks {
+		if err := block.require("block", false, false); err != nil {
+			return nil, err
+		}
+		spec.DefineBlock(Symbol(block.Open), Symbol(block.Close))
+	}
+	for _, start := range grammar.IndentedBlocks {
+		spec.DefineIndentedBlock(Symbol(start))
+	}
+	for _, parens := range grammar.Parens {
+		if err := parens.require("parens", false, false); err != nil {
+			return nil, err
+		}
+		spec.DefineParens(Symbol(parens.Open), Symbol(parens.Close))
+	}
+	for _, call := range grammar.Calls {
+		if err := call.require("call", true, false); err != nil {
+			return nil, err
+		}
+		spec.DefineCall(Symbol(call.Open), Symbol(call.Close), Symbol(call.Separator))
+	}
+	for _, index := range grammar.Indexes {
+		if err := index.require("index", false, false); err != nil {
+			return nil, err
+		}
+		spec.DefineIndex(Symbol(index.Open), Symbol(index.Close))
+	}
+	for _, dot := range grammar.MemberAccess {
+		spec.DefineMemberAccess(Symbol(dot))
+	}
+	for _, list := range grammar.Lists {
+		if err := list.require("list", true, false); err != nil {
+			return nil, err
+		}
+		spec.DefineListLiteral(Symbol(list.Open), Symbol(list.Close), Symbol(list.Separator))
+	}
+	for _, literal := range grammar.Maps {
+		if err := literal.require("map", true, true); err != nil {
+			return nil, err
+		}
+		spec.DefineMapLiteral(Symbol(literal.Open), Symbol(literal.Close), Symbol(literal.Colon), Symbol(literal.Separator))
+	}
+	for _, ternary := range grammar.Ternaries {
+		if ternary.Question == "" || ternary.Colon == "" {
+			return nil, fmt.Errorf("grammarerror: ternaries need both a question and a colon symbol")
+		}
+		spec.DefineTernary(Symbol(ternary.Question), Symbol(ternary.Colon), ternary.Precedence)
+	}
+	if grammar.Strict {
+		if errs := spec.Validate(); len(errs) > 0 {
+			return nil, fmt.Errorf("grammarerror: %v", errs[0])
+		}
+	}
+	return spec, nil
+}
+
+// Checks that a delimited construct has the fields it needs
+func (delimiter DelimiterGrammar) require(construct string, separator bool, colon bool) error {
+	if delimiter.Open == "" || delimiter.Close == "" {
+		return fmt.Errorf("grammarerror: %v needs both an open and a close delimiter", construct)
+	}
+	if separator && delimiter.Separator == "" {
+		return fmt.Errorf("grammarerror: %v %v needs a separator", construct, delimiter.Open)
+	}
+	if colon && delimiter.Colon == "" {
+		return fmt.Errorf("grammarerror: %v %v needs a colon", construct, delimiter.Open)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package langkit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testGrammar = `{
+	"operators": [
+		{"symbol": "=", "kind": "infix", "precedence": 10, "associativity": "right"},
+		{"symbol": "+", "kind": "infix", "precedence": 60},
+		{"symbol": "*", "kind": "infix", "precedence": 70},
+		{"symbol": "^", "kind": "infix", "precedence": 75, "associativity": "right"},
+		{"symbol": "-", "kind": "prefix", "precedence": 80},
+		{"symbol": "!", "kind": "postfix", "precedence": 90}
+	],
+	"values": ["true", "false"],
+	"statements": ["if"],
+	"quotes": [{"open": "'", "close": "'", "escapes": ["c"]}],
+	"numbers": {"hex": true, "separator": "_"},
+	"lineComments": ["#"],
+	"blockComments": [{"open": "(*", "close": "*)", "nestable": true}],
+	"newlineTerminator": ";",
+	"blocks": [{"open": "{", "close": "}"}],
+	"parens": [{"open": "(", "close": ")"}],
+	"calls": [{"open": "(", "close": ")", "separator": ","}],
+	"lists": [{"open": "[", "close": "]", "separator": ","}],
+	"ternaries": [{"question": "?", "colon": ":", "precedence": 20}]
+}`
+
+func testStatements() map[string]StdFunction {
+	return map[string]StdFunction{
+		"if": func(t *Token, p *TDOPParser) (*Token, error) {
+			condition, err := p.Expression(0)
+			if err != nil {
+				return nil, err
+			}
+			block, err := p.Block()
+			if err != nil {
+				return nil, err
+			}
+			t.Children = append(t.Children, condition, block)
+			return t, nil
+		},
+	}
+}
+
+func TestLoadGrammar(t *testing.T) {
+	spec, err := LoadGrammar(strings.NewReader(testGrammar), testStatements())
+	if err != nil {
+		t.Fatalf("Unexpected grammar error %v", err)
+	}
+	source := "# a comment\nx = y = -2 ^ 3 ^ 0x1_0!\nif true { f('a\\n', [1, 2]) } (* (* nested *) *)\nz = x ? 1 : 2 + 3 * 4"
+	parser := NewParser(NewLexer(strings.NewReader(source), spec))
+	statements, err := parser.Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	shapes := []string{}
+	for _, statement := range statements {
+		shapes = append(shapes, treeShape(statement))
+	}
+	expected := "[(= x (= y (^ (- 2) (^ 3 (! 0x1_0))))) (if true ({ (( f a\n ([ 1 2)))) (= z (? x 1 (+ 2 (* 3 4))))]"
+	if fmt.Sprint(shapes) != expected {
+		t.Fatalf("Expected %v, got %v", expected, shapes)
+	}
+}
+
+func TestLoadGrammarErrors(t *testing.T) {
+	testCases := map[string]string{
+		`{"operators": [{"symbol": "+", "kind": "circumfix"}]}`:                        "unknown kind",
+		`{"operators": [{"symbol": "-", "kind": "prefix", "associativity": "right"}]}`: "cannot be right-associative",
+		`{"operators": [{"symbol": "+", "kind": "infix", "associativity": "up"}]}`:     "unknown associativity",
+		`{"statements": ["while"]}`:                                                    "no std function",
+		`{"quotes": [{"open": "'", "close": "'", "escapes": ["perl"]}]}`:               "unknown escapes",
+		`{"numbers": {"separator": "__"}}`:                                             "not a single character",
+		`{"calls": [{"open": "(", "close": ")"}]}`:                                     "needs a separator",
+		`{"maps": [{"open": "{", "close": "}", "separator": ","}]}`:                    "needs a colon",
+		`{"strict": true, "operators": [{"symbol": "-", "kind": "infix", "precedence": 60}, {"symbol": "-", "kind": "infix", "precedence": 70}]}`: "already has a led",
+		`{"strict": true, "values": ["end"], "blocks": [{"open": "do", "close": "end"}]}`:                                                         "also a value",
+		`{"operator": []}`: "unknown field",
+		`{"operators": `:   "unexpected EOF",
+	}
+	for input, message := range testCases {
+		t.Run(input, func(t *testing.T) {
+			_, err := LoadGrammar(strings.NewReader(input), testStatements())
+			if err == nil || !strings.Contains(err.Error(), message) || !strings.HasPrefix(err.Error(), "grammarerror: ") {
+				t.Fatalf("Expected an error containing %q, got %v", message, err)
+			}
+		})
+	}
+}
@@ -12,6 +12,8 @@ type Exception error
 type Engine interface {
 	Execute(source io.Reader) (Value, Exception)
 	ExecuteWithGlobals(source io.Reader, globals VariableValues) (Value, Exception)
+	// Traces the lexing and parsing of subsequently executed sources
+	SetTracer(tracer Tracer)
 }
 
 type Interpreter interface {
@@ -32,12 +34,21 @@ func NewEngine(symbolTable LanguageSpecification, intepreter Interpreter) Engine
 type EngineImpl struct {
 	lexerFactory func(io.Reader) Lexer
 	interpreter  Interpreter
+	tracer       Tracer
+}
+
+func (engine *EngineImpl) SetTracer(tracer Tracer) {
+	engine.tracer = tracer
 }
 
 func (engine *EngineImpl) ExecuteWithGlobals(source io.Reader, globals VariableValues) (Value, Exception) {
 	lexer := engine.lexerFactory(source)
+	if traced, ok := lexer.(tracedLexer); ok {
+		traced.SetTracer(engine.tracer)
+	}
 	parser := TDOPParser{
-		Lexer: lexer,
+		Lexer:  lexer,
+		Tracer: engine.tracer,
 	}
 
 	trees, err := parser.Statements()
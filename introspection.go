@@ -0,0 +1,84 @@
+package langkit
+
+import "sort"
+
+// Identifies the construct a Define helper declared
+type FormKind string
+
+const (
+	ValueForm             FormKind = "value"
+	PrefixForm            FormKind = "prefix"
+	InfixForm             FormKind = "infix"
+	PostfixForm           FormKind = "postfix"
+	TernaryForm           FormKind = "ternary"
+	ParensForm            FormKind = "parens"
+	CallForm              FormKind = "call"
+	IndexForm             FormKind = "index"
+	MemberAccessForm      FormKind = "memberaccess"
+	ListForm              FormKind = "list"
+	MapForm               FormKind = "map"
+	StatementForm         FormKind = "statement"
+	BlockForm             FormKind = "block"
+	IndentedBlockForm     FormKind = "indentedblock"
+	TerminatorForm        FormKind = "terminator"
+	NewlineTerminatorForm FormKind = "newlineterminator"
+	QuoteForm             FormKind = "quote"
+	LineCommentForm       FormKind = "linecomment"
+	BlockCommentForm      FormKind = "blockcomment"
+)
+
+// A construct declared through one of the Define helpers. Symbol is
+// the operator or opening delimiter; the other fields are set only
+// for the kinds of form that use them.
+type Form struct {
+	Kind             FormKind
+	Symbol           Symbol
+	BindingPower     int
+	RightAssociative bool
+	Close            Symbol
+	Separator        Symbol
+	Colon            Symbol
+	// The literal type of quotes and the escapes they understand
+	Literal Symbol
+	Escapes EscapePolicy
+	// Whether block comments nest
+	Nestable bool
+}
+
+// Describes a defined symbol and the parsing functions bound to it
+type SymbolInfo struct {
+	Symbol       Symbol
+	BindingPower int
+	Arity        int
+	HasNud       bool
+	HasLed       bool
+	HasStd       bool
+}
+
+func (spec *languageSpecificationImpl) recordForm(form Form) {
+	spec.forms = append(spec.forms, form)
+}
+
+func (spec *languageSpecificationImpl) Forms() []Form {
+	forms := make([]Form, len(spec.forms))
+	copy(forms, spec.forms)
+	return forms
+}
+
+func (spec *languageSpecificationImpl) Symbols() []SymbolInfo {
+	infos := []SymbolInfo{}
+	for symbol, token := range spec.symbols {
+		infos = append(infos, SymbolInfo{
+			Symbol:       symbol,
+			BindingPower: token.BindingPower,
+			Arity:        token.Arity,
+			HasNud:       token.Nud != nil,
+			HasLed:       token.Led != nil,
+			HasStd:       token.Std != nil,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Symbol < infos[j].Symbol
+	})
+	return infos
+}
@@ -2,7 +2,9 @@ package langkit
 
 import (
 	"fmt"
+	"sort"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Represents a collection of symbols
@@ -15,35 +17,120 @@ type LanguageSpecification interface {
 	Define(symbol Symbol, bindingPower int, arity int, nud NudFunction, led LedFunction, std StdFunction)
 
 	DefineInfix(symbol Symbol, bindingPower int)
+	// Defines a right-associative infix operator, so that a ^ b ^ c
+	// parses as a ^ (b ^ c)
+	DefineInfixRight(symbol Symbol, bindingPower int)
 	DefineValue(symbol Symbol)
 	DefinePrefix(symbol Symbol, bindingPower int)
+	// Defines an operator following its single operand, such as x++
+	DefinePostfix(symbol Symbol, bindingPower int)
+	// Defines a conditional operator such as cond ? a : b, parsed
+	// into a node with the condition and both branches as children
+	DefineTernary(question Symbol, colon Symbol, bindingPower int)
 	DefineParens(openParens Symbol, closeParens Symbol)
+	// Defines calls such as f(a, b), parsed into FunctionInvocation
+	// nodes whose first child is the callee followed by the arguments
+	DefineCall(open Symbol, close Symbol, separator Symbol)
+	// Defines indexing such as a[i], parsed into Index nodes
+	DefineIndex(open Symbol, close Symbol)
+	// Defines member access such as a.b, parsed into MemberAccess nodes
+	// whose children are the target and the member name
+	DefineMemberAccess(dot Symbol)
+	// Defines list literals such as [a, b], parsed into ListLiteral
+	// nodes with one child per element
+	DefineListLiteral(open Symbol, close Symbol, separator Symbol)
+	// Defines map literals such as {a: b}, parsed into MapLiteral nodes
+	// whose children are MapEntry nodes holding a key and a value. When
+	// open also starts a block, it opens a block at the start of a
+	// statement and a map anywhere else.
+	DefineMapLiteral(open Symbol, close Symbol, colon Symbol, separator Symbol)
 	DefineQuotes(openQuote rune, closeQuote rune, literalType Symbol)
+	// Defines a quoted literal with multi-character delimiters and
+	// control over escape sequences and newlines
+	DefineQuotesWithOptions(openQuote string, closeQuote string, literalType Symbol, options QuoteOptions)
+	// Configures which forms of numeric literal the lexer accepts
+	DefineNumbers(options NumberOptions)
+	GetNumberOptions() *NumberOptions
+	// Defines a comment running from prefix to the end of the line
+	DefineLineComment(prefix string)
+	// Defines a comment delimited by open and close, which may contain
+	// further such comments if nestable is set
+	DefineBlockComment(open string, close string, nestable bool)
 	// Generates a token for the given symbol and a given value
 	GenerateToken(symbol Symbol, value string, line int, col int) *Token
 	//
 	Eof(line int, col int) *Token
-	GetQuoteSpec(openQuote rune) *quoteSpecification
+	GetQuoteSpecs(start rune) []*quoteSpecification
+	GetCommentSpecs(start rune) []*commentSpecification
 	IsIdentifierCharacter(character rune) bool
 	IsIdentifierStartChararacter(character rune) bool
 	DefineStatementTerminator(symbol Symbol)
 	IsStatementTerminator(symbol Symbol) bool
+	// Makes the lexer end statements at newlines by emitting the given
+	// terminator, as long as the line ends with a value, a postfix
+	// operator or a closing delimiter and is not inside a grouping
+	DefineNewlineTerminator(symbol Symbol)
+	GetNewlineTerminator() (Symbol, bool)
+	// Declares a pair of delimiters inside which newlines never end
+	// statements. Parens, calls, indexing and collection literals
+	// declare their delimiters automatically.
+	DefineGrouping(open Symbol, close Symbol)
+	GetGroupingClose(open Symbol) (Symbol, bool)
 	DefineEmpty(symbol Symbol)
 	DefineBlock(startSymbol Symbol, endSymbol Symbol)
 	IsBlockStart(symbol Symbol) bool
+	// Defines a block opened by startSymbol and continuing with the
+	// following lines indented further than the line it starts on,
+	// such as Python's suites after a colon. The lexer then emits
+	// Indent and Dedent tokens at changes of indentation.
+	DefineIndentedBlock(startSymbol Symbol)
+	UsesIndentation() bool
+	// Returns the symbol that ends a block opened by startSymbol
+	GetBlockEnd(startSymbol Symbol) Symbol
+	// Lists every defined symbol, ordered by symbol
+	Symbols() []SymbolInfo
+	// Lists the constructs declared through the Define helpers, in
+	// the order they were declared
+	Forms() []Form
 	IsBlockEnd(symbol Symbol, startSymbol Symbol) bool
 	DefineStatment(symbol Symbol, std StdFunction)
 	IsAnyBlockEnd(symbol Symbol) bool
+	// In strict mode a definition that conflicts with an earlier one,
+	// such as a second infix definition of the same operator, is
+	// rejected and reported by Validate instead of being merged.
+	// Repeating an identical definition is always allowed.
+	SetStrict(strict bool)
+	// Reports the conflicts rejected in strict mode followed by any
+	// definitions the lexer or parser cannot honour
+	Validate() []error
+	// Returns an independent copy that can be changed without
+	// affecting this specification
+	Clone() LanguageSpecification
+	// Adds every definition of base that this specification does not
+	// override. In strict mode a definition of base that conflicts
+	// with one made here is an error.
+	Extend(base LanguageSpecification) error
+	// Removes the forms of a symbol along with the quotes, comments,
+	// terminators, blocks and groupings it opens. A symbol that other
+	// forms still expect stays defined without the removed functions.
+	Remove(symbol Symbol)
+	// Returns an immutable copy with precomputed lexical tables, which
+	// is faster to lex with and safe to share between goroutines. The
+	// copy panics if anything tries to change it.
+	Compile() LanguageSpecification
 }
 
 func NewLanguage() LanguageSpecification {
 	symbols := make(map[Symbol]*Token)
-	quotes := make(map[rune]*quoteSpecification)
+	quotes := make(map[rune][]*quoteSpecification)
 	language := &languageSpecificationImpl{
 		quoteDefinitions:     quotes,
+		commentDefinitions:   map[rune][]*commentSpecification{},
+		numberOptions:        &NumberOptions{},
 		symbols:              symbols,
 		statementTerminators: []Symbol{},
 		blockDelimiters:      map[Symbol]Symbol{},
+		groupings:            map[Symbol]Symbol{},
 	}
 	language.DefineValue(Name)
 	language.DefineValue(IntLiteral)
@@ -51,17 +138,77 @@ func NewLanguage() LanguageSpecification {
 	return language
 }
 
+// Determines which escape sequences a quoted literal understands.
+// Policies may be combined, as in CEscapes | UnicodeEscapes.
+type EscapePolicy int
+
+const (
+	// Backslashes are copied verbatim, as in a raw string
+	NoEscapes EscapePolicy = 0
+	// \n, \t, \r, \0, \a, \b, \f, \v, \\, \', \", \xHH and an
+	// escaped closing quote. \xHH stands for the code point U+00HH,
+	// so that values are always valid UTF-8.
+	CEscapes EscapePolicy = 1
+	// \u{...} with between one and six hex digits
+	UnicodeEscapes EscapePolicy = 2
+)
+
+type QuoteOptions struct {
+	Escapes EscapePolicy
+	// Allows the literal to span several lines
+	Multiline bool
+	// Parses literals of this kind, for instance to split a template
+	// string into its parts. Literals are plain values when nil.
+	Nud NudFunction
+}
+
 type quoteSpecification struct {
-	openQuote   rune
-	closeQuote  rune
+	openQuote   string
+	closeQuote  string
 	literalType Symbol
+	escapes     EscapePolicy
+	multiline   bool
+}
+
+// Describes the numeric literals of a language. Plain decimal integers
+// and floats such as 12 and 1.5 are always accepted.
+type NumberOptions struct {
+	// Accept 0x, 0o and 0b prefixed integers
+	HexPrefix    bool
+	OctalPrefix  bool
+	BinaryPrefix bool
+	// Accept decimal exponents such as 1e-9
+	Exponents bool
+	// A character allowed between digits, such as '_', or zero for none
+	DigitSeparator rune
+	// Maps type suffixes such as "u" or "f32" to the symbol of the
+	// literals carrying them
+	Suffixes map[string]Symbol
 }
 
+// A line comment has an empty close
+type commentSpecification struct {
+	open     string
+	close    string
+	nestable bool
+}
+
+// The binding power of calls, indexing and member access, which
+// bind more tightly than any ordinary operator
+const AccessBindingPower = 1000
+
 type languageSpecificationImpl struct {
-	quoteDefinitions     map[rune]*quoteSpecification
+	quoteDefinitions     map[rune][]*quoteSpecification
+	commentDefinitions   map[rune][]*commentSpecification
+	numberOptions        *NumberOptions
 	symbols              map[Symbol]*Token
 	statementTerminators []Symbol
 	blockDelimiters      map[Symbol]Symbol
+	groupings            map[Symbol]Symbol
+	newlineTerminator    Symbol
+	forms                []Form
+	strict               bool
+	conflicts            []error
 }
 
 func (spec *languageSpecificationImpl) IsAnyBlockEnd(symbol Symbol) bool {
@@ -74,30 +221,82 @@ func (spec *languageSpecificationImpl) IsAnyBlockEnd(symbol Symbol) bool {
 }
 
 func (spec *languageSpecificationImpl) DefineBlock(startSymbol Symbol, endSymbol Symbol) {
-	spec.DefineEmpty(endSymbol)
+	form := Form{Kind: BlockForm, Symbol: startSymbol, Close: endSymbol}
 
 	std := func(token *Token, parser *TDOPParser) (*Token, error) {
 		statements, err := parser.Statements()
 		if err != nil {
-			fmt.Printf("Error! %v\n", err)
 			return nil, err
 		}
+		end, err := parser.Lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		if end.Symbol != endSymbol {
+			return nil, NewSyntaxError(UnexpectedToken, fmt.Sprintf("expected %v to close block, but got %v", endSymbol, end.describe()), end)
+		}
 		token.Children = append(token.Children, statements...)
 		token.Symbol = Block
+		token.Span = token.Span.Union(end.Span)
 		return token, nil
 	}
 
-	spec.DefineStatment(startSymbol, std)
+	if !spec.define(startSymbol, 0, 0, nil, nil, std, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(endSymbol)
 	spec.blockDelimiters[startSymbol] = endSymbol
 	spec.statementTerminators = append(spec.statementTerminators, endSymbol)
 }
 
+func (spec *languageSpecificationImpl) DefineIndentedBlock(startSymbol Symbol) {
+	form := Form{Kind: IndentedBlockForm, Symbol: startSymbol, Close: Dedent}
+
+	std := func(token *Token, parser *TDOPParser) (*Token, error) {
+		indent, err := parser.Lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		if indent.Symbol != Indent {
+			return nil, NewSyntaxError(ExpectedBlockStart, fmt.Sprintf("expected an indented block after %v, but got %v", startSymbol, indent.describe()), indent)
+		}
+		statements, err := parser.Statements()
+		if err != nil {
+			return nil, err
+		}
+		if err = expectSymbol(parser, Dedent); err != nil {
+			return nil, err
+		}
+		token.Children = append(token.Children, statements...)
+		token.Symbol = Block
+		return token, nil
+	}
+
+	if !spec.define(startSymbol, 0, 0, nil, nil, std, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(Indent)
+	spec.DefineEmpty(Dedent)
+	spec.blockDelimiters[startSymbol] = Dedent
+	spec.statementTerminators = append(spec.statementTerminators, Dedent)
+}
+
+func (spec *languageSpecificationImpl) UsesIndentation() bool {
+	return spec.IsAnyBlockEnd(Dedent)
+}
+
 func (spec *languageSpecificationImpl) IsBlockStart(symbol Symbol) bool {
 	if _, found := spec.blockDelimiters[symbol]; found {
 		return true
 	}
 	return false
 }
+func (spec *languageSpecificationImpl) GetBlockEnd(startSymbol Symbol) Symbol {
+	return spec.blockDelimiters[startSymbol]
+}
+
 func (spec *languageSpecificationImpl) IsBlockEnd(symbol Symbol, startSymbol Symbol) bool {
 	end, found := spec.blockDelimiters[startSymbol]
 	if !found {
@@ -119,7 +318,37 @@ func (spec *languageSpecificationImpl) IsStatementTerminator(symbol Symbol) bool
 	return false
 }
 
+func (spec *languageSpecificationImpl) DefineNewlineTerminator(symbol Symbol) {
+	if !spec.IsStatementTerminator(symbol) && !spec.defineStatementTerminator(symbol) {
+		return
+	}
+	spec.recordForm(Form{Kind: NewlineTerminatorForm, Symbol: symbol})
+	spec.newlineTerminator = symbol
+}
+
+func (spec *languageSpecificationImpl) GetNewlineTerminator() (Symbol, bool) {
+	return spec.newlineTerminator, spec.newlineTerminator != ""
+}
+
+func (spec *languageSpecificationImpl) DefineGrouping(open Symbol, close Symbol) {
+	spec.groupings[open] = close
+}
+
+func (spec *languageSpecificationImpl) GetGroupingClose(open Symbol) (Symbol, bool) {
+	close, found := spec.groupings[open]
+	return close, found
+}
+
 func (spec *languageSpecificationImpl) DefineStatementTerminator(symbol Symbol) {
+	spec.defineStatementTerminator(symbol)
+}
+
+func (spec *languageSpecificationImpl) defineStatementTerminator(symbol Symbol) bool {
+	if existing, found := spec.symbols[symbol]; found && spec.strict && (existing.Nud != nil || existing.Led != nil || existing.Std != nil) {
+		spec.conflicts = append(spec.conflicts, newSpecificationError(ConflictingDefinition, symbol, "terminator %v is already defined as an operator", symbol))
+		return false
+	}
+	spec.recordForm(Form{Kind: TerminatorForm, Symbol: symbol})
 	spec.statementTerminators = append(spec.statementTerminators, symbol)
 	spec.symbols[symbol] = &Token{
 		Symbol:       symbol,
@@ -130,6 +359,7 @@ func (spec *languageSpecificationImpl) DefineStatementTerminator(symbol Symbol)
 		Std:          nil,
 		Children:     []*Token{},
 	}
+	return true
 }
 
 func (spec *languageSpecificationImpl) IsIdentifierStartChararacter(char rune) bool {
@@ -140,14 +370,63 @@ func (spec *languageSpecificationImpl) IsIdentifierCharacter(char rune) bool {
 	_, found := spec.symbols[Symbol(string(char))]
 	if found {
 		return false
+	} else if _, found := spec.commentDefinitions[char]; found {
+		return false
 	} else {
 		return !unicode.IsSpace(char)
 	}
 }
 
-func (spec *languageSpecificationImpl) GetQuoteSpec(openQuote rune) *quoteSpecification {
-	val, _ := spec.quoteDefinitions[openQuote]
-	return val
+func (spec *languageSpecificationImpl) GetQuoteSpecs(start rune) []*quoteSpecification {
+	return spec.quoteDefinitions[start]
+}
+
+func (spec *languageSpecificationImpl) DefineNumbers(options NumberOptions) {
+	suffixes := map[Symbol]bool{}
+	for _, symbol := range options.Suffixes {
+		if !suffixes[symbol] {
+			spec.DefineValue(symbol)
+			suffixes[symbol] = true
+		}
+	}
+	spec.numberOptions = &options
+}
+
+func (spec *languageSpecificationImpl) GetNumberOptions() *NumberOptions {
+	return spec.numberOptions
+}
+
+func (spec *languageSpecificationImpl) GetCommentSpecs(start rune) []*commentSpecification {
+	return spec.commentDefinitions[start]
+}
+
+func (spec *languageSpecificationImpl) DefineLineComment(prefix string) {
+	spec.recordForm(Form{Kind: LineCommentForm, Symbol: Symbol(prefix)})
+	spec.defineComment(&commentSpecification{
+		open: prefix,
+	})
+}
+
+func (spec *languageSpecificationImpl) DefineBlockComment(open string, close string, nestable bool) {
+	spec.recordForm(Form{Kind: BlockCommentForm, Symbol: Symbol(open), Close: Symbol(close), Nestable: nestable})
+	spec.defineComment(&commentSpecification{
+		open:     open,
+		close:    close,
+		nestable: nestable,
+	})
+}
+
+func (spec *languageSpecificationImpl) defineComment(commentSpec *commentSpecification) {
+	if commentSpec.open == "" {
+		return
+	}
+	start, _ := utf8.DecodeRuneInString(commentSpec.open)
+	commentSpecs := append(spec.commentDefinitions[start], commentSpec)
+	// Try longer openers first so that, say, "/**" wins over "/*"
+	sort.SliceStable(commentSpecs, func(i, j int) bool {
+		return len(commentSpecs[i].open) > len(commentSpecs[j].open)
+	})
+	spec.commentDefinitions[start] = commentSpecs
 }
 
 func (spec *languageSpecificationImpl) IsDefined(symbol Symbol) bool {
@@ -156,20 +435,23 @@ func (spec *languageSpecificationImpl) IsDefined(symbol Symbol) bool {
 }
 
 func (spec *languageSpecificationImpl) Define(symbol Symbol, bindingPower int, arity int, nud NudFunction, led LedFunction, std StdFunction) {
+	spec.define(symbol, bindingPower, arity, nud, led, std, nil)
+}
+
+// Defines a symbol on behalf of form, which is nil for direct calls
+// to Define. Repeating a form is never a conflict in strict mode.
+// Returns false if strict mode rejected the definition, in which case
+// the form should not be recorded.
+func (spec *languageSpecificationImpl) define(symbol Symbol, bindingPower int, arity int, nud NudFunction, led LedFunction, std StdFunction, form *Form) bool {
 	existing, found := spec.symbols[symbol]
 	if found {
-		if nud != nil && existing.Nud == nil {
-			existing.Nud = nud
-		}
-		if led != nil && existing.Led == nil {
-			existing.Led = led
-		}
-		if std != nil && existing.Std == nil {
-			existing.Std = std
-		}
-		if bindingPower > existing.BindingPower {
-			existing.BindingPower = bindingPower
+		if spec.strict && !spec.repeatsForm(form) {
+			if conflict := definitionConflict(symbol, existing, nud, led, std); conflict != nil {
+				spec.conflicts = append(spec.conflicts, conflict)
+				return false
+			}
 		}
+		mergeDefinition(existing, bindingPower, nud, led, std)
 	} else {
 		token := Token{
 			Symbol:       symbol,
@@ -185,6 +467,24 @@ func (spec *languageSpecificationImpl) Define(symbol Symbol, bindingPower int, a
 		}
 		spec.symbols[symbol] = &token
 	}
+	return true
+}
+
+// Gives existing any functions it lacks, keeping the larger
+// binding power
+func mergeDefinition(existing *Token, bindingPower int, nud NudFunction, led LedFunction, std StdFunction) {
+	if nud != nil && existing.Nud == nil {
+		existing.Nud = nud
+	}
+	if led != nil && existing.Led == nil {
+		existing.Led = led
+	}
+	if std != nil && existing.Std == nil {
+		existing.Std = std
+	}
+	if bindingPower > existing.BindingPower {
+		existing.BindingPower = bindingPower
+	}
 }
 
 func (spec *languageSpecificationImpl) GenerateToken(symbol Symbol, value string, line int, col int) *Token {
@@ -221,15 +521,52 @@ func (spec *languageSpecificationImpl) Eof(line int, col int) *Token {
 }
 
 func (spec *languageSpecificationImpl) DefineQuotes(openQuote rune, closeQuote rune, literalType Symbol) {
-	spec.quoteDefinitions[openQuote] = &quoteSpecification{
+	spec.DefineQuotesWithOptions(string(openQuote), string(closeQuote), literalType, QuoteOptions{})
+}
+
+func (spec *languageSpecificationImpl) DefineQuotesWithOptions(openQuote string, closeQuote string, literalType Symbol, options QuoteOptions) {
+	form := Form{Kind: QuoteForm, Symbol: Symbol(openQuote), Close: Symbol(closeQuote), Literal: literalType, Escapes: options.Escapes}
+	if openQuote == "" || closeQuote == "" {
+		return
+	}
+	start, _ := utf8.DecodeRuneInString(openQuote)
+	quoteSpecs := []*quoteSpecification{}
+	for _, existing := range spec.quoteDefinitions[start] {
+		if existing.openQuote != openQuote {
+			quoteSpecs = append(quoteSpecs, existing)
+		} else if spec.strict && (existing.closeQuote != closeQuote || existing.literalType != literalType) {
+			spec.conflicts = append(spec.conflicts, newSpecificationError(ConflictingDefinition, Symbol(openQuote), "quote %v is already defined", openQuote))
+			return
+		}
+	}
+	quoteSpecs = append(quoteSpecs, &quoteSpecification{
 		openQuote:   openQuote,
 		closeQuote:  closeQuote,
 		literalType: literalType,
+		escapes:     options.Escapes,
+		multiline:   options.Multiline,
+	})
+	// Try longer quotes first so that, say, """ wins over "
+	sort.SliceStable(quoteSpecs, func(i, j int) bool {
+		return len(quoteSpecs[i].openQuote) > len(quoteSpecs[j].openQuote)
+	})
+	index := len(spec.forms)
+	accepted := false
+	if options.Nud != nil {
+		accepted = spec.define(literalType, 0, 0, options.Nud, nil, nil, &form)
+	} else {
+		accepted = spec.defineValue(literalType)
 	}
-	spec.DefineValue(literalType)
+	if !accepted {
+		return
+	}
+	spec.quoteDefinitions[start] = quoteSpecs
+	// The quote precedes the value form of its literal type
+	spec.forms = append(spec.forms[:index], append([]Form{form}, spec.forms[index:]...)...)
 }
 
 func (spec *languageSpecificationImpl) DefineInfix(symbol Symbol, bindingPower int) {
+	form := Form{Kind: InfixForm, Symbol: symbol, BindingPower: bindingPower}
 	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
 		t.Children = append(t.Children, left)
 		exprResult, err := parser.Expression(t.BindingPower)
@@ -239,10 +576,72 @@ func (spec *languageSpecificationImpl) DefineInfix(symbol Symbol, bindingPower i
 		t.Children = append(t.Children, exprResult)
 		return t, nil
 	}
-	spec.Define(symbol, bindingPower, 2, nil, led, nil)
+	if !spec.define(symbol, bindingPower, 2, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+}
+
+func (spec *languageSpecificationImpl) DefineInfixRight(symbol Symbol, bindingPower int) {
+	form := Form{Kind: InfixForm, Symbol: symbol, BindingPower: bindingPower, RightAssociative: true}
+	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
+		t.Children = append(t.Children, left)
+		// Parsing the right operand just below our own binding power
+		// lets a following use of the same operator bind first
+		exprResult, err := parser.Expression(t.BindingPower - 1)
+		if err != nil {
+			return nil, err
+		}
+		t.Children = append(t.Children, exprResult)
+		return t, nil
+	}
+	if !spec.define(symbol, bindingPower, 2, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+}
+
+func (spec *languageSpecificationImpl) DefinePostfix(symbol Symbol, bindingPower int) {
+	form := Form{Kind: PostfixForm, Symbol: symbol, BindingPower: bindingPower}
+	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
+		t.Children = append(t.Children, left)
+		return t, nil
+	}
+	if !spec.define(symbol, bindingPower, 1, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+}
+
+func (spec *languageSpecificationImpl) DefineTernary(question Symbol, colon Symbol, bindingPower int) {
+	form := Form{Kind: TernaryForm, Symbol: question, BindingPower: bindingPower, RightAssociative: true, Colon: colon}
+	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
+		t.Children = append(t.Children, left)
+		consequent, err := parser.Expression(0)
+		if err != nil {
+			return nil, err
+		}
+		t.Children = append(t.Children, consequent)
+		if err = expectSymbol(parser, colon); err != nil {
+			return nil, err
+		}
+		// Right-associative, so that a ? b : c ? d : e nests to the right
+		alternative, err := parser.Expression(t.BindingPower - 1)
+		if err != nil {
+			return nil, err
+		}
+		t.Children = append(t.Children, alternative)
+		return t, nil
+	}
+	if !spec.define(question, bindingPower, 3, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(colon)
 }
 
 func (spec *languageSpecificationImpl) DefinePrefix(symbol Symbol, bindingPower int) {
+	form := Form{Kind: PrefixForm, Symbol: symbol, BindingPower: bindingPower}
 	nud := func(t *Token, parser *TDOPParser) (*Token, error) {
 		expResult, err := parser.Expression(bindingPower)
 		if err != nil {
@@ -251,29 +650,231 @@ func (spec *languageSpecificationImpl) DefinePrefix(symbol Symbol, bindingPower
 		t.Children = append(t.Children, expResult)
 		return t, nil
 	}
-	spec.Define(symbol, bindingPower, 1, nud, nil, nil)
+	if !spec.define(symbol, bindingPower, 1, nud, nil, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
 }
 
 // come up with a better name for this
 func (spec *languageSpecificationImpl) DefineValue(symbol Symbol) {
+	spec.defineValue(symbol)
+}
+
+func (spec *languageSpecificationImpl) defineValue(symbol Symbol) bool {
+	form := Form{Kind: ValueForm, Symbol: symbol}
 	nud := func(t *Token, p *TDOPParser) (*Token, error) {
 		return t, nil
 	}
-	spec.Define(symbol, 0, 0, nud, nil, nil)
+	if !spec.define(symbol, 0, 0, nud, nil, nil, &form) {
+		return false
+	}
+	spec.recordForm(form)
+	return true
 }
 
 func (spec *languageSpecificationImpl) DefineParens(openParens Symbol, closeParens Symbol) {
+	form := Form{Kind: ParensForm, Symbol: openParens, Close: closeParens}
 	nud := func(t *Token, p *TDOPParser) (*Token, error) {
 		expressionToken, err := p.Expression(0)
 		if err != nil {
 			return nil, err
 		}
+		if err = expectSymbol(p, closeParens); err != nil {
+			return nil, err
+		}
 		return expressionToken, nil
 	}
-	spec.Define(openParens, 0, 0, nud, nil, nil)
-	spec.DefineValue(closeParens)
+	if !spec.define(openParens, 0, 0, nud, nil, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(closeParens)
+	spec.DefineGrouping(openParens, closeParens)
+}
+
+func (spec *languageSpecificationImpl) DefineCall(open Symbol, close Symbol, separator Symbol) {
+	form := Form{Kind: CallForm, Symbol: open, BindingPower: AccessBindingPower, Close: close, Separator: separator}
+	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
+		arguments, err := parseDelimited(parser, close, separator, parseElement)
+		if err != nil {
+			return nil, err
+		}
+		t.Children = append([]*Token{left}, arguments...)
+		t.Symbol = FunctionInvocation
+		t.Arity = len(t.Children)
+		return t, nil
+	}
+	if !spec.define(open, AccessBindingPower, 0, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(close)
+	spec.DefineEmpty(separator)
+	spec.DefineGrouping(open, close)
+}
+
+func (spec *languageSpecificationImpl) DefineIndex(open Symbol, close Symbol) {
+	form := Form{Kind: IndexForm, Symbol: open, BindingPower: AccessBindingPower, Close: close}
+	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
+		index, err := parser.Expression(0)
+		if err != nil {
+			return nil, err
+		}
+		if err = expectSymbol(parser, close); err != nil {
+			return nil, err
+		}
+		t.Children = append(t.Children, left, index)
+		t.Symbol = Index
+		t.Arity = 2
+		return t, nil
+	}
+	if !spec.define(open, AccessBindingPower, 2, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(close)
+	spec.DefineGrouping(open, close)
+}
+
+func (spec *languageSpecificationImpl) DefineMemberAccess(dot Symbol) {
+	form := Form{Kind: MemberAccessForm, Symbol: dot, BindingPower: AccessBindingPower}
+	led := func(t *Token, parser *TDOPParser, left *Token) (*Token, error) {
+		member, err := parser.Lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		if member.Symbol != Name {
+			return nil, NewSyntaxError(UnexpectedToken, fmt.Sprintf("expected member name after %v, but got %v", dot, member.describe()), member)
+		}
+		t.Children = append(t.Children, left, member)
+		t.Symbol = MemberAccess
+		t.Arity = 2
+		return t, nil
+	}
+	if !spec.define(dot, AccessBindingPower, 2, nil, led, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+}
+
+func (spec *languageSpecificationImpl) DefineListLiteral(open Symbol, close Symbol, separator Symbol) {
+	form := Form{Kind: ListForm, Symbol: open, Close: close, Separator: separator}
+	nud := func(t *Token, parser *TDOPParser) (*Token, error) {
+		elements, err := parseDelimited(parser, close, separator, parseElement)
+		if err != nil {
+			return nil, err
+		}
+		t.Children = elements
+		t.Symbol = ListLiteral
+		t.Arity = len(elements)
+		return t, nil
+	}
+	if !spec.define(open, 0, 0, nud, nil, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(close)
+	spec.DefineEmpty(separator)
+	spec.DefineGrouping(open, close)
+}
+
+func (spec *languageSpecificationImpl) DefineMapLiteral(open Symbol, close Symbol, colon Symbol, separator Symbol) {
+	form := Form{Kind: MapForm, Symbol: open, Close: close, Colon: colon, Separator: separator}
+	parseEntry := func(parser *TDOPParser) (*Token, error) {
+		key, err := parser.Expression(0)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := parser.Lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry.Symbol != colon {
+			return nil, NewSyntaxError(UnexpectedToken, fmt.Sprintf("expected %v, but got %v", colon, entry.describe()), entry)
+		}
+		value, err := parser.Expression(0)
+		if err != nil {
+			return nil, err
+		}
+		entry.Children = append(entry.Children, key, value)
+		entry.Symbol = MapEntry
+		entry.Arity = 2
+		return entry, nil
+	}
+	nud := func(t *Token, parser *TDOPParser) (*Token, error) {
+		entries, err := parseDelimited(parser, close, separator, parseEntry)
+		if err != nil {
+			return nil, err
+		}
+		t.Children = entries
+		t.Symbol = MapLiteral
+		t.Arity = len(entries)
+		return t, nil
+	}
+	if !spec.define(open, 0, 0, nud, nil, nil, &form) {
+		return
+	}
+	spec.recordForm(form)
+	spec.DefineEmpty(close)
+	spec.DefineEmpty(colon)
+	spec.DefineEmpty(separator)
+	spec.DefineGrouping(open, close)
+}
+
+func parseElement(parser *TDOPParser) (*Token, error) {
+	return parser.Expression(0)
+}
+
+// Parses items divided by separator up to and including close,
+// allowing a trailing separator
+func parseDelimited(parser *TDOPParser, close Symbol, separator Symbol, parseItem func(*TDOPParser) (*Token, error)) ([]*Token, error) {
+	items := []*Token{}
+	for {
+		next, err := parser.Lexer.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if next.Symbol == close {
+			if _, err := parser.Lexer.Next(); err != nil {
+				return nil, err
+			}
+			return items, nil
+		}
+		item, err := parseItem(parser)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		next, err = parser.Lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		if next.Symbol == close {
+			return items, nil
+		}
+		if next.Symbol != separator {
+			return nil, NewSyntaxError(UnexpectedToken, fmt.Sprintf("expected %v or %v, but got %v", separator, close, next.describe()), next)
+		}
+	}
+}
+
+// Consumes the next token, failing unless it is the expected symbol
+func expectSymbol(parser *TDOPParser, symbol Symbol) error {
+	next, err := parser.Lexer.Next()
+	if err != nil {
+		return err
+	}
+	if next.Symbol != symbol {
+		return NewSyntaxError(UnexpectedToken, fmt.Sprintf("expected %v, but got %v", symbol, next.describe()), next)
+	}
+	return nil
 }
 
 func (spec *languageSpecificationImpl) DefineStatment(symbol Symbol, std StdFunction) {
-	spec.Define(symbol, 0, 0, nil, nil, std)
+	form := Form{Kind: StatementForm, Symbol: symbol}
+	if !spec.define(symbol, 0, 0, nil, nil, std, &form) {
+		return
+	}
+	spec.recordForm(form)
 }
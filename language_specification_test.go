@@ -1 +1,239 @@
 package langkit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	charLiteral     Symbol = "(CHAR)"
+	templateLiteral Symbol = "(TEMPLATE)"
+)
+
+// Splits a template such as `Hello ${name}!` into string and name parts
+func templateNud(t *Token, parser *TDOPParser) (*Token, error) {
+	remaining := t.Value
+	for remaining != "" {
+		start := strings.Index(remaining, "${")
+		end := strings.Index(remaining, "}")
+		if start < 0 || end < start {
+			t.Children = append(t.Children, &Token{Symbol: StringLiteral, Value: remaining, Line: t.Line, Col: t.Col})
+			break
+		}
+		if start > 0 {
+			t.Children = append(t.Children, &Token{Symbol: StringLiteral, Value: remaining[:start], Line: t.Line, Col: t.Col})
+		}
+		t.Children = append(t.Children, &Token{Symbol: Name, Value: remaining[start+2 : end], Line: t.Line, Col: t.Col})
+		remaining = remaining[end+1:]
+	}
+	return t, nil
+}
+
+func TestQuotesProduceConfiguredLiteralType(t *testing.T) {
+	spec := NewLanguage()
+	spec.DefineInfix("+", 10)
+	spec.DefineQuotes('"', '"', StringLiteral)
+	spec.DefineQuotes('\'', '\'', charLiteral)
+	spec.DefineQuotesWithOptions("`", "`", templateLiteral, QuoteOptions{Nud: templateNud})
+
+	parser := NewParser(NewLexer(strings.NewReader("\"a\" + 'b' + `Hello ${name}!`"), spec))
+	tree, err := parser.Expression(0)
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+
+	expected := &Token{
+		Symbol:       "+",
+		Value:        "+",
+		Line:         1,
+		Arity:        2,
+		BindingPower: 10,
+		Children: []*Token{
+			{
+				Symbol:       "+",
+				Value:        "+",
+				Line:         1,
+				Arity:        2,
+				BindingPower: 10,
+				Children: []*Token{
+					{Symbol: StringLiteral, Value: "a", Line: 1},
+					{Symbol: charLiteral, Value: "b", Line: 1},
+				},
+			},
+			{
+				Symbol: templateLiteral,
+				Value:  "Hello ${name}!",
+				Line:   1,
+				Children: []*Token{
+					{Symbol: StringLiteral, Value: "Hello ", Line: 1},
+					{Symbol: Name, Value: "name", Line: 1},
+					{Symbol: StringLiteral, Value: "!", Line: 1},
+				},
+			},
+		},
+	}
+	equivalent, message := areTokensEquivalent(expected, tree)
+	if !equivalent {
+		t.Fatalf("%v\n%v", message, tree.TreeString(0))
+	}
+}
+
+// Renders a tree compactly, such as (+ A (* B C))
+func treeShape(token *Token) string {
+	if len(token.Children) == 0 {
+		return token.Value
+	}
+	parts := []string{token.Value}
+	for _, child := range token.Children {
+		parts = append(parts, treeShape(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestOperatorAssociativityAndArity(t *testing.T) {
+	spec := NewLanguage()
+	spec.DefineInfixRight("=", 10)
+	spec.DefineTernary("?", ":", 20)
+	spec.DefineInfix("+", 30)
+	spec.DefineInfix("-", 30)
+	spec.DefineInfixRight("^", 50)
+	spec.DefinePrefix("-", 60)
+	spec.DefinePostfix("!", 70)
+	spec.DefinePostfix("++", 70)
+	spec.DefineParens("(", ")")
+
+	testCases := map[string]string{
+		"A - B - C":             "(- (- A B) C)",
+		"A ^ B ^ C":             "(^ A (^ B C))",
+		"A = B = C + D":         "(= A (= B (+ C D)))",
+		"A + B !":               "(+ A (! B))",
+		"- A ++":                "(- (++ A))",
+		"A ! ^ B":               "(^ (! A) B)",
+		"A ? B : C":             "(? A B C)",
+		"A ? B : C ? D : E":     "(? A B (? C D E))",
+		"A ? B ? C : D : E":     "(? A (? B C D) E)",
+		"X = A + B ? C : D - E": "(= X (? (+ A B) C (- D E)))",
+		"(A ? B : C) + D":       "(+ (? A B C) D)",
+	}
+	for input, expected := range testCases {
+		t.Run(input, func(t *testing.T) {
+			parser := NewParser(NewLexer(strings.NewReader(input), spec))
+			tree, err := parser.Expression(0)
+			if err != nil {
+				t.Fatalf("Unexpected parsing error %v", err)
+			}
+			if treeShape(tree) != expected {
+				t.Fatalf("Expected %v, got %v", expected, treeShape(tree))
+			}
+		})
+	}
+
+	parser := NewParser(NewLexer(strings.NewReader("A ? B C"), spec))
+	_, err := parser.Expression(0)
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) || syntaxError.Token.Value != "C" {
+		t.Fatalf("Expected an error at the missing colon, got %v", err)
+	}
+}
+
+func TestCallIndexAndMemberAccess(t *testing.T) {
+	spec := NewLanguage()
+	spec.DefineInfix("+", 30)
+	spec.DefinePrefix("-", 60)
+	spec.DefineParens("(", ")")
+	spec.DefineCall("(", ")", ",")
+	spec.DefineIndex("[", "]")
+	spec.DefineMemberAccess(".")
+
+	testCases := map[string]string{
+		"f()":           "(( f)",
+		"f(A)":          "(( f A)",
+		"f(A, B + C,)":  "(( f A (+ B C))",
+		"a.b.c(D)[E]":   "([ (( (. (. a b) c) D) E)",
+		"-a[B + C] + D": "(+ (- ([ a (+ B C))) D)",
+		"(f)(A)(B)":     "(( (( f A) B)",
+		"a[f(B)].c + D": "(+ (. ([ a (( f B)) c) D)",
+	}
+	for input, expected := range testCases {
+		t.Run(input, func(t *testing.T) {
+			parser := NewParser(NewLexer(strings.NewReader(input), spec))
+			tree, err := parser.Expression(0)
+			if err != nil {
+				t.Fatalf("Unexpected parsing error %v", err)
+			}
+			if treeShape(tree) != expected {
+				t.Fatalf("Expected %v, got %v", expected, treeShape(tree))
+			}
+		})
+	}
+
+	parser := NewParser(NewLexer(strings.NewReader("a.b(C)[D]"), spec))
+	tree, err := parser.Expression(0)
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	if tree.Symbol != Index || tree.Children[0].Symbol != FunctionInvocation || tree.Children[0].Children[0].Symbol != MemberAccess {
+		t.Fatalf("Unexpected node symbols\n%v", tree.TreeString(0))
+	}
+
+	for _, input := range []string{"f(A B)", "a[B", "a.(B)", "f(A,"} {
+		parser := NewParser(NewLexer(strings.NewReader(input), spec))
+		_, err := parser.Expression(0)
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) {
+			t.Fatalf("Expected a syntax error parsing %v, got %v", input, err)
+		}
+	}
+}
+
+func TestListAndMapLiterals(t *testing.T) {
+	spec := NewLanguage()
+	spec.DefineInfixRight("=", 10)
+	spec.DefineInfix("+", 30)
+	spec.DefineStatementTerminator(";")
+	spec.DefineBlock("{", "}")
+	spec.DefineListLiteral("[", "]", ",")
+	spec.DefineIndex("[", "]")
+	spec.DefineMapLiteral("{", "}", ":", ",")
+
+	testCases := map[string]string{
+		"X = [];":                  "(= X [)",
+		"X = [A, B + C,];":         "(= X ([ A (+ B C)))",
+		"X = [[A], [B]][C];":       "(= X ([ ([ ([ A) ([ B)) C))",
+		"X = {};":                  "(= X {)",
+		"X = {A: B, C + D: [E],};": "(= X ({ (: A B) (: (+ C D) ([ E))))",
+		"{ X = {A: {}}; }":         "({ (= X ({ (: A {))))",
+	}
+	for input, expected := range testCases {
+		t.Run(input, func(t *testing.T) {
+			parser := NewParser(NewLexer(strings.NewReader(input), spec))
+			statements, err := parser.Statements()
+			if err != nil {
+				t.Fatalf("Unexpected parsing error %v", err)
+			}
+			if len(statements) != 1 || treeShape(statements[0]) != expected {
+				t.Fatalf("Expected %v, got %v", expected, treeShape(statements[0]))
+			}
+		})
+	}
+
+	parser := NewParser(NewLexer(strings.NewReader("{ X = {A: B}; }"), spec))
+	statements, err := parser.Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	block := statements[0]
+	if block.Symbol != Block || block.Children[0].Children[1].Symbol != MapLiteral || block.Children[0].Children[1].Children[0].Symbol != MapEntry {
+		t.Fatalf("Expected a block containing a map literal\n%v", block.TreeString(0))
+	}
+
+	for _, input := range []string{"X = [A B];", "X = {A};", "X = {A: B C};", "X = [,];"} {
+		parser := NewParser(NewLexer(strings.NewReader(input), spec))
+		_, err := parser.Statements()
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) {
+			t.Fatalf("Expected a syntax error parsing %v, got %v", input, err)
+		}
+	}
+}
@@ -2,11 +2,13 @@ package langkit
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type LexerState int
@@ -20,6 +22,7 @@ const (
 	whiteSpace               = 5
 	operator                 = 6
 	eof                      = 7
+	comment                  = 8
 )
 
 func (state LexerState) String() string {
@@ -40,6 +43,8 @@ func (state LexerState) String() string {
 		return "operator"
 	case eof:
 		return "eof"
+	case comment:
+		return "comment"
 	}
 	return fmt.Sprint(int(state))
 }
@@ -51,28 +56,130 @@ type Lexer interface {
 	IsBlockStart(token *Token) bool
 	IsAnyBlockEnd(token *Token) bool
 	IsBlockEnd(token *Token, blockStart *Token) bool
+	// Returns the kth upcoming token without consuming it, where
+	// PeekN(1) is equivalent to Peek
+	PeekN(k int) (*Token, error)
+	// Marks the current position so that it can be returned to
+	// with Reset. Every mark must be either reset or released.
+	Mark() int
+	// Returns to a marked position and discards the mark
+	Reset(mark int)
+	// Discards a mark, keeping the current position
+	Release(mark int)
+}
+
+// Implemented by lexers that can report the tokens they produce to
+// a tracer
+type tracedLexer interface {
+	SetTracer(tracer Tracer)
 }
 
 func NewLexer(reader io.Reader, symbolTable LanguageSpecification) *TDOPLexer {
-	return &TDOPLexer{
+	return NewFileLexer(reader, "", symbolTable)
+}
+
+// Creates a lexer whose token spans name the file being read
+func NewFileLexer(reader io.Reader, fileName string, symbolTable LanguageSpecification) *TDOPLexer {
+	start := Position{
+		Line:   1,
+		Col:    1,
+		Offset: 0,
+	}
+	lexer := &TDOPLexer{
 		reader:       bufio.NewReader(reader),
 		languageSpec: symbolTable,
-		cachedToken:  nil,
-		line:         1,
-		col:          0,
+		fileName:     fileName,
+		position:     start,
+		charPosition: start,
+		atLineStart:  true,
+		lineStart:    start,
+	}
+	if lexicon, ok := symbolTable.(operatorLexicon); ok {
+		lexer.operators = lexicon.operatorTrie()
 	}
+	lexer.numberOptions = symbolTable.GetNumberOptions()
+	return lexer
 }
 
 type TDOPLexer struct {
-	reader            *bufio.Reader
-	languageSpec      LanguageSpecification
-	cachedToken       *Token
-	line              int
-	col               int
+	reader       *bufio.Reader
+	languageSpec LanguageSpecification
+	// Tokens read ahead of the parser, or kept for marks. The next
+	// token to return is buffer[cursor], and buffer[0] is the token
+	// numbered bufferStart in the whole stream.
+	buffer      []*Token
+	cursor      int
+	bufferStart int
+	marks       []int
+	fileName    string
+	// The position of the next character to be read
+	position Position
+	// The position of the most recently read character
+	charPosition      Position
 	builder           strings.Builder
 	currentState      LexerState
-	tokenStartCol     int
-	currentQuoteStart rune
+	tokenStart        Position
+	currentQuote      *quoteSpecification
+	currentComment    *commentSpecification
+	commentDepth      int
+	numberBase        int
+	numberHasExponent bool
+	numberSuffix      strings.Builder
+	numberOptions     *NumberOptions
+	retainComments    bool
+	comments          []*Token
+	tracer            Tracer
+	// State for retaining trivia. Every token returned by Next is
+	// logged so that the parser can find those left out of the tree.
+	retainTrivia bool
+	source       bytes.Buffer
+	triviaStart  int
+	lastRaw      *Token
+	consumed     []*Token
+	// The trie of a compiled specification's symbols and the node
+	// reached by the operator being read
+	operators     *operatorNode
+	operatorState *operatorNode
+	// State for inserting newline terminators
+	lastEndsStatement bool
+	lastNeedsOperand  bool
+	groupings         []openGrouping
+	newlinePending    bool
+	newlinePosition   Position
+	// State for the offside rule. The indentation of each enclosing
+	// indented block is stacked, and INDENT and DEDENT tokens wait in
+	// pending until the token they precede has been read.
+	atLineStart bool
+	lineStart   Position
+	lineIndent  strings.Builder
+	indents     []string
+	pending     []*Token
+}
+
+// Reports every token the lexer produces to the tracer. A nil
+// tracer, the default, disables tracing.
+func (lexer *TDOPLexer) SetTracer(tracer Tracer) {
+	lexer.tracer = tracer
+}
+
+// Instructs the lexer to keep the comments it skips so that
+// tools can retrieve them with Comments
+func (lexer *TDOPLexer) RetainComments() {
+	lexer.retainComments = true
+}
+
+// Instructs the lexer to record the source text of every token along
+// with the whitespace and comments around it, and the parser to keep
+// the punctuation it consumes, so that Source reproduces the input
+// exactly. Must be called before the first token is read.
+func (lexer *TDOPLexer) RetainTrivia() {
+	lexer.retainTrivia = true
+	lexer.reader = bufio.NewReader(io.TeeReader(lexer.reader, &lexer.source))
+}
+
+// Returns the comments skipped so far, if retained
+func (lexer *TDOPLexer) Comments() []*Token {
+	return lexer.comments
 }
 
 func (lexer *TDOPLexer) IsBlockStart(token *Token) bool {
@@ -90,165 +197,833 @@ func (lexer *TDOPLexer) IsStatementTerminator(token *Token) bool {
 	return lexer.languageSpec.IsStatementTerminator(token.Symbol)
 }
 
-func (lexer *TDOPLexer) syntaxError(msg string) error {
-	return fmt.Errorf("syntaxerror: "+msg+" at line %v, col %v", lexer.line, lexer.col)
+// Creates a syntax error located at the most recently read character.
+// The partially read token is abandoned so that lexing can resume
+// after the error.
+func (lexer *TDOPLexer) syntaxError(kind SyntaxErrorKind, format string, args ...interface{}) error {
+	lexer.abandonToken()
+	return &SyntaxError{
+		Kind:      kind,
+		Message:   fmt.Sprintf(format, args...),
+		File:      lexer.fileName,
+		StartLine: lexer.charPosition.Line,
+		StartCol:  lexer.charPosition.Col,
+		EndLine:   lexer.position.Line,
+		EndCol:    lexer.position.Col,
+		Offset:    lexer.charPosition.Offset,
+	}
+}
+
+// Creates a syntax error spanning from the start of the current
+// token to the most recently read character, abandoning the token
+func (lexer *TDOPLexer) tokenSyntaxError(kind SyntaxErrorKind, format string, args ...interface{}) error {
+	lexer.abandonToken()
+	return &SyntaxError{
+		Kind:      kind,
+		Message:   fmt.Sprintf(format, args...),
+		File:      lexer.fileName,
+		StartLine: lexer.tokenStart.Line,
+		StartCol:  lexer.tokenStart.Col,
+		EndLine:   lexer.position.Line,
+		EndCol:    lexer.position.Col,
+		Offset:    lexer.tokenStart.Offset,
+	}
+}
+
+func (lexer *TDOPLexer) abandonToken() {
+	lexer.builder = strings.Builder{}
+	lexer.currentState = unknown
+	lexer.currentComment = nil
+	lexer.currentQuote = nil
+	lexer.numberSuffix = strings.Builder{}
+}
+
+// Generates a token starting at the current token start and ending
+// just before end
+func (lexer *TDOPLexer) generateToken(symbol Symbol, value string, end Position) (*Token, error) {
+	token := lexer.languageSpec.GenerateToken(symbol, value, lexer.tokenStart.Line, lexer.tokenStart.Col)
+	if token == nil {
+		return nil, lexer.tokenSyntaxError(InvalidLexerState, "undefined symbol %v", symbol)
+	}
+	token.Span = Span{
+		File:  lexer.fileName,
+		Start: lexer.tokenStart,
+		End:   end,
+	}
+	return token, nil
+}
+
+func (lexer *TDOPLexer) eofToken() *Token {
+	token := lexer.languageSpec.Eof(lexer.position.Line, lexer.position.Col)
+	token.Span = Span{
+		File:  lexer.fileName,
+		Start: lexer.position,
+		End:   lexer.position,
+	}
+	return token
+}
+
+// Checks whether the input following the most recently read
+// character continues with rest, consuming it if so
+func (lexer *TDOPLexer) consumeIfNext(rest string) bool {
+	if rest == "" {
+		return true
+	}
+	upcoming, err := lexer.reader.Peek(len(rest))
+	if err != nil || string(upcoming) != rest {
+		return false
+	}
+	for range rest {
+		lexer.readRune()
+	}
+	return true
+}
+
+// Consumes the remainder of a comment opener starting with char,
+// returning the matching comment specification if there is one
+func (lexer *TDOPLexer) matchComment(char rune) *commentSpecification {
+	for _, commentSpec := range lexer.languageSpec.GetCommentSpecs(char) {
+		if lexer.consumeIfNext(commentSpec.open[utf8.RuneLen(char):]) {
+			return commentSpec
+		}
+	}
+	return nil
+}
+
+// Consumes the remainder of an opening quote starting with char,
+// returning the matching quote specification if there is one
+func (lexer *TDOPLexer) matchQuote(char rune) *quoteSpecification {
+	for _, quoteSpec := range lexer.languageSpec.GetQuoteSpecs(char) {
+		if lexer.consumeIfNext(quoteSpec.openQuote[utf8.RuneLen(char):]) {
+			return quoteSpec
+		}
+	}
+	return nil
+}
+
+// Reads the escape sequence following a backslash in a string literal
+// and writes the character it denotes into the literal
+func (lexer *TDOPLexer) readEscape(quoteSpec *quoteSpecification) error {
+	char, _, err := lexer.readRune()
+	if err != nil {
+		return lexer.tokenSyntaxError(UnterminatedString, "unexpected EOF in escape sequence")
+	}
+	if quoteSpec.escapes&CEscapes != 0 {
+		if escaped, found := cEscapes[char]; found {
+			lexer.builder.WriteRune(escaped)
+			return nil
+		}
+		if strings.HasPrefix(quoteSpec.closeQuote, string(char)) {
+			lexer.builder.WriteRune(char)
+			return nil
+		}
+		if char == 'x' {
+			value, err := lexer.readHexDigits(2, 2, 0)
+			if err != nil {
+				return err
+			}
+			lexer.builder.WriteRune(rune(value))
+			return nil
+		}
+	}
+	if quoteSpec.escapes&UnicodeEscapes != 0 && char == 'u' {
+		open, _, err := lexer.readRune()
+		if err != nil || open != '{' {
+			return lexer.syntaxError(InvalidEscape, "expected { in unicode escape sequence")
+		}
+		value, err := lexer.readHexDigits(1, 6, '}')
+		if err != nil {
+			return err
+		}
+		if value > unicode.MaxRune || (value >= 0xD800 && value <= 0xDFFF) {
+			return lexer.syntaxError(InvalidEscape, "invalid unicode code point %X", value)
+		}
+		lexer.builder.WriteRune(rune(value))
+		return nil
+	}
+	return lexer.syntaxError(InvalidEscape, "invalid escape sequence \\%v", string(char))
+}
+
+// Reads between min and max hex digits, stopping early only at the
+// given terminator, which is consumed. A terminator of 0 means the
+// digits run for exactly max characters.
+func (lexer *TDOPLexer) readHexDigits(min int, max int, terminator rune) (int, error) {
+	value := 0
+	digits := 0
+	for {
+		char, _, err := lexer.readRune()
+		if err != nil {
+			return 0, lexer.tokenSyntaxError(UnterminatedString, "unexpected EOF in escape sequence")
+		}
+		if terminator != 0 && char == terminator && digits >= min {
+			return value, nil
+		}
+		digit, isHex := hexValue(char)
+		if !isHex || digits == max {
+			return 0, lexer.syntaxError(InvalidEscape, "invalid hex digit %v in escape sequence", string(char))
+		}
+		value = value*16 + digit
+		digits++
+		if terminator == 0 && digits == max {
+			return value, nil
+		}
+	}
+}
+
+func hexValue(char rune) (int, bool) {
+	switch {
+	case char >= '0' && char <= '9':
+		return int(char - '0'), true
+	case char >= 'a' && char <= 'f':
+		return int(char-'a') + 10, true
+	case char >= 'A' && char <= 'F':
+		return int(char-'A') + 10, true
+	}
+	return 0, false
+}
+
+var cEscapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'v':  '\v',
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
+
+// Adds char to the numeric literal being read if it belongs there,
+// returning false if char starts the next token
+func (lexer *TDOPLexer) continueNumber(char rune) (bool, error) {
+	options := lexer.numberOptions
+	if lexer.numberSuffix.Len() > 0 {
+		if lexer.languageSpec.IsIdentifierCharacter(char) {
+			lexer.numberSuffix.WriteRune(char)
+			return true, nil
+		}
+		return false, nil
+	}
+	text := lexer.builder.String()
+	last, _ := utf8.DecodeLastRuneInString(text)
+	switch {
+	case text == "0" && ((options.HexPrefix && (char == 'x' || char == 'X')) ||
+		(options.OctalPrefix && (char == 'o' || char == 'O')) ||
+		(options.BinaryPrefix && (char == 'b' || char == 'B'))):
+		lexer.numberBase = numberBases[unicode.ToLower(char)]
+	case isDigitInBase(char, lexer.numberBase):
+	case options.DigitSeparator != 0 && char == options.DigitSeparator:
+	case char == '.' && lexer.numberBase == 10:
+		if lexer.currentState == floatLiteral {
+			return false, lexer.tokenSyntaxError(MalformedNumber, "unexpected %v in number %v", string(char), text)
+		}
+		lexer.currentState = floatLiteral
+	case (char == 'e' || char == 'E') && options.Exponents && lexer.numberBase == 10 && !lexer.numberHasExponent:
+		lexer.currentState = floatLiteral
+		lexer.numberHasExponent = true
+	case (char == '+' || char == '-') && (last == 'e' || last == 'E') && lexer.numberHasExponent:
+	case unicode.IsDigit(char):
+		return false, lexer.tokenSyntaxError(MalformedNumber, "invalid digit %v in base %v number %v", string(char), lexer.numberBase, text)
+	case lexer.languageSpec.IsIdentifierStartChararacter(char):
+		if len(options.Suffixes) == 0 {
+			return false, lexer.tokenSyntaxError(MalformedNumber, "unexpected %v in number %v", string(char), text)
+		}
+		lexer.numberSuffix.WriteRune(char)
+		return true, nil
+	default:
+		return false, nil
+	}
+	lexer.builder.WriteRune(char)
+	return true, nil
+}
+
+// Validates the numeric literal that has been read and generates
+// its token. Digit separators and prefixes are kept in the value,
+// while any type suffix selects the token's symbol.
+func (lexer *TDOPLexer) endOfNumber() (*Token, error) {
+	options := lexer.numberOptions
+	text := lexer.builder.String()
+	digits := text
+	if lexer.numberBase != 10 {
+		digits = text[2:]
+	}
+	if digits == "" {
+		return nil, lexer.tokenSyntaxError(MalformedNumber, "missing digits in number %v", text)
+	}
+	last, _ := utf8.DecodeLastRuneInString(text)
+	if last == '+' || last == '-' || ((last == 'e' || last == 'E') && lexer.numberHasExponent) {
+		return nil, lexer.tokenSyntaxError(MalformedNumber, "missing exponent in number %v", text)
+	}
+	if options.DigitSeparator != 0 {
+		runes := []rune(digits)
+		for i, char := range runes {
+			if char != options.DigitSeparator {
+				continue
+			}
+			// Separators may only appear between two digits
+			if i == 0 || i == len(runes)-1 || !isDigitInBase(runes[i-1], lexer.numberBase) || !isDigitInBase(runes[i+1], lexer.numberBase) {
+				return nil, lexer.tokenSyntaxError(MalformedNumber, "misplaced digit separator in number %v", text)
+			}
+		}
+	}
+	symbol := IntLiteral
+	if lexer.currentState == floatLiteral {
+		symbol = FloatLiteral
+	}
+	if lexer.numberSuffix.Len() > 0 {
+		suffix := lexer.numberSuffix.String()
+		suffixSymbol, found := options.Suffixes[suffix]
+		if !found {
+			return nil, lexer.tokenSyntaxError(MalformedNumber, "unknown suffix %v on number %v", suffix, text)
+		}
+		symbol = suffixSymbol
+	}
+	lexer.builder = strings.Builder{}
+	lexer.numberSuffix = strings.Builder{}
+	return lexer.generateToken(symbol, text, lexer.charPosition)
+}
+
+var numberBases = map[rune]int{
+	'x': 16,
+	'o': 8,
+	'b': 2,
+}
+
+func isDigitInBase(char rune, base int) bool {
+	switch base {
+	case 2:
+		return char == '0' || char == '1'
+	case 8:
+		return char >= '0' && char <= '7'
+	case 16:
+		_, isHex := hexValue(char)
+		return isHex
+	}
+	return char >= '0' && char <= '9'
+}
+
+func (lexer *TDOPLexer) endOfComment() {
+	// Line comments are ended by reading the newline after them
+	end := lexer.position
+	if lexer.currentComment.close == "" {
+		end = lexer.charPosition
+	}
+	if lexer.retainComments {
+		lexer.comments = append(lexer.comments, &Token{
+			Symbol:   Comment,
+			Value:    lexer.builder.String(),
+			Line:     lexer.tokenStart.Line,
+			Col:      lexer.tokenStart.Col,
+			Children: []*Token{},
+			Span: Span{
+				File:  lexer.fileName,
+				Start: lexer.tokenStart,
+				End:   end,
+			},
+		})
+	}
+	lexer.builder = strings.Builder{}
+	lexer.currentComment = nil
 }
 
 func (lexer *TDOPLexer) startOfToken(char rune) {
-	if quoteSpec := lexer.languageSpec.GetQuoteSpec(char); quoteSpec != nil {
+	lexer.tokenStart = lexer.charPosition
+	if commentSpec := lexer.matchComment(char); commentSpec != nil {
+		lexer.currentState = comment
+		lexer.currentComment = commentSpec
+		lexer.commentDepth = 1
+		lexer.builder.WriteString(commentSpec.open)
+	} else if quoteSpec := lexer.matchQuote(char); quoteSpec != nil {
 		lexer.currentState = stringLiteral
-		lexer.tokenStartCol = lexer.col
-		lexer.currentQuoteStart = char
-		// Don't write the quote character into the string literal
+		lexer.currentQuote = quoteSpec
+		// Don't write the quote characters into the string literal
 	} else if lexer.languageSpec.IsIdentifierStartChararacter(char) {
 		lexer.currentState = name
-		lexer.tokenStartCol = lexer.col
 		lexer.builder.WriteRune(char)
 	} else if unicode.IsDigit(char) {
 		lexer.currentState = intLiteral
-		lexer.tokenStartCol = lexer.col
+		lexer.numberBase = 10
+		lexer.numberHasExponent = false
+		lexer.numberSuffix = strings.Builder{}
 		lexer.builder.WriteRune(char)
 	} else if unicode.IsSpace(char) {
 		lexer.currentState = whiteSpace
+		// The token this ends has yet to be returned, so any terminator
+		// for the newline must wait until the next scan
+		if char == '\n' {
+			lexer.startOfLine()
+			lexer.newlinePending = true
+			lexer.newlinePosition = lexer.charPosition
+		}
 	} else {
 		lexer.currentState = operator
-		lexer.tokenStartCol = lexer.col
 		lexer.builder.WriteRune(char)
+		if lexer.operators != nil {
+			lexer.operatorState = lexer.operators.children[char]
+		}
+	}
+}
+
+// Reports whether char extends the operator being read to a defined
+// symbol, and whether the operator read so far is defined itself
+func (lexer *TDOPLexer) continueOperator(char rune) (bool, bool) {
+	if lexer.operators != nil {
+		if lexer.operatorState == nil {
+			return false, false
+		}
+		if next := lexer.operatorState.children[char]; next != nil && next.defined {
+			lexer.operatorState = next
+			return true, true
+		}
+		return false, lexer.operatorState.defined
+	}
+	currentString := lexer.builder.String()
+	if lexer.languageSpec.IsDefined(Symbol(currentString + string(char))) {
+		return true, true
 	}
+	return false, lexer.languageSpec.IsDefined(Symbol(currentString))
 }
 
+// Generates the token that has been read. Tokens other than quoted
+// literals are ended by reading the character after them.
 func (lexer *TDOPLexer) endOfToken() (*Token, error) {
 	switch lexer.currentState {
 	case stringLiteral:
-		quoteSpec := lexer.languageSpec.GetQuoteSpec(lexer.currentQuoteStart)
-		if quoteSpec == nil {
-			return nil, fmt.Errorf("syntaxerror: invalid quoted literal with quote %v at line %v, col %v", string(lexer.currentQuoteStart), lexer.line, lexer.col)
+		if lexer.currentQuote == nil {
+			return nil, lexer.tokenSyntaxError(InvalidLexerState, "invalid quoted literal")
 		}
 		stringVal := lexer.builder.String()
-		token := lexer.languageSpec.GenerateToken(StringLiteral, stringVal, lexer.line, lexer.tokenStartCol)
-		lexer.tokenStartCol = lexer.col
-		lexer.builder = strings.Builder{}
-		return token, nil
-	case intLiteral:
-		stringVal := lexer.builder.String()
-		token := lexer.languageSpec.GenerateToken(IntLiteral, stringVal, lexer.line, lexer.tokenStartCol)
 		lexer.builder = strings.Builder{}
-		lexer.tokenStartCol = lexer.col
-		return token, nil
-	case floatLiteral:
-		stringVal := lexer.builder.String()
-		token := lexer.languageSpec.GenerateToken(FloatLiteral, stringVal, lexer.line, lexer.tokenStartCol)
-		lexer.builder = strings.Builder{}
-		lexer.tokenStartCol = lexer.col
-		return token, nil
+		return lexer.generateToken(lexer.currentQuote.literalType, stringVal, lexer.position)
+	case intLiteral, floatLiteral:
+		return lexer.endOfNumber()
 	case name:
 		stringVal := lexer.builder.String()
-		var token *Token
+		lexer.builder = strings.Builder{}
 		if lexer.languageSpec.IsDefined(Symbol(stringVal)) {
-			token = lexer.languageSpec.GenerateToken(Symbol(stringVal), stringVal, lexer.line, lexer.tokenStartCol)
-		} else {
-			token = lexer.languageSpec.GenerateToken(Name, stringVal, lexer.line, lexer.tokenStartCol)
+			return lexer.generateToken(Symbol(stringVal), stringVal, lexer.charPosition)
 		}
-		lexer.builder = strings.Builder{}
-		lexer.tokenStartCol = lexer.col
-		return token, nil
+		return lexer.generateToken(Name, stringVal, lexer.charPosition)
 	case operator:
 		stringVal := lexer.builder.String()
-		var token *Token
-		if lexer.languageSpec.IsDefined(Symbol(stringVal)) {
-			token = lexer.languageSpec.GenerateToken(Symbol(stringVal), stringVal, lexer.line, lexer.tokenStartCol)
-		} else {
-			return nil, fmt.Errorf("syntaxerror: unidentified operator %v at line %v, col %v", stringVal, lexer.line, lexer.col)
+		if !lexer.languageSpec.IsDefined(Symbol(stringVal)) {
+			return nil, lexer.tokenSyntaxError(UnrecognizedOperator, "unidentified operator %v", stringVal)
 		}
 		lexer.builder = strings.Builder{}
-		lexer.tokenStartCol = lexer.col
-		return token, nil
+		return lexer.generateToken(Symbol(stringVal), stringVal, lexer.charPosition)
 	case whiteSpace:
-		return nil, fmt.Errorf("syntaxerror: attempted to resolve token in whitespace at line %v, col %v", lexer.line, lexer.col)
+		return nil, lexer.syntaxError(InvalidLexerState, "attempted to resolve token in whitespace")
 	default:
-		return nil, fmt.Errorf("syntaxerror: attempted to resolve token in unkown parse state at line %v, col %v", lexer.line, lexer.col)
+		return nil, lexer.syntaxError(InvalidLexerState, "attempted to resolve token in unkown parse state")
 	}
 }
 
 func (lexer *TDOPLexer) Peek() (*Token, error) {
-	token, err := lexer.Next()
-	if err != nil {
-		return nil, err
+	return lexer.PeekN(1)
+}
+
+func (lexer *TDOPLexer) PeekN(k int) (*Token, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("cannot peek %v tokens ahead", k)
 	}
-	lexer.cachedToken = token
-	return token, nil
+	for len(lexer.buffer)-lexer.cursor < k {
+		token, err := lexer.scan()
+		if err != nil {
+			return nil, err
+		}
+		if lexer.tracer != nil {
+			lexer.tracer.TokenEmitted(token)
+		}
+		if lexer.retainTrivia {
+			lexer.attachTrivia(token)
+		}
+		lexer.buffer = append(lexer.buffer, token)
+	}
+	return lexer.buffer[lexer.cursor+k-1], nil
 }
 
-func (lexer *TDOPLexer) readRune() (rune, int, error) {
+func (lexer *TDOPLexer) Mark() int {
+	mark := lexer.bufferStart + lexer.cursor
+	lexer.marks = append(lexer.marks, mark)
+	return mark
+}
+
+func (lexer *TDOPLexer) Reset(mark int) {
+	if lexer.retainTrivia {
+		// Tokens read since the mark will be read again
+		for _, token := range lexer.consumed[mark:] {
+			token.claimed = false
+		}
+		lexer.consumed = lexer.consumed[:mark]
+	}
+	lexer.removeMark(mark)
+	lexer.cursor = mark - lexer.bufferStart
+	lexer.compact()
+}
+
+func (lexer *TDOPLexer) Release(mark int) {
+	lexer.removeMark(mark)
+	lexer.compact()
+}
+
+func (lexer *TDOPLexer) removeMark(mark int) {
+	for i := len(lexer.marks) - 1; i >= 0; i-- {
+		if lexer.marks[i] == mark {
+			lexer.marks = append(lexer.marks[:i], lexer.marks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Drops consumed tokens once no mark can return to them
+func (lexer *TDOPLexer) compact() {
+	if len(lexer.marks) > 0 || lexer.cursor == 0 {
+		return
+	}
+	remaining := copy(lexer.buffer, lexer.buffer[lexer.cursor:])
+	for i := remaining; i < len(lexer.buffer); i++ {
+		lexer.buffer[i] = nil
+	}
+	lexer.buffer = lexer.buffer[:remaining]
+	lexer.bufferStart += lexer.cursor
+	lexer.cursor = 0
+}
 
+func (lexer *TDOPLexer) readRune() (rune, int, error) {
 	char, size, err := lexer.reader.ReadRune()
+	// At EOF the current position stands in for the missing character
+	lexer.charPosition = lexer.position
+	lexer.position.Offset += size
+	if size == 0 {
+		return char, size, err
+	}
 	if char == '\n' {
-		lexer.line++
-		lexer.col = 1
+		lexer.position.Line++
+		lexer.position.Col = 1
 	} else {
-		lexer.col++
+		lexer.position.Col++
 	}
 	return char, size, err
 }
 
 func (lexer *TDOPLexer) Next() (*Token, error) {
-	// First check to see if we have a cached token
-	// from a call to Peek
-	if lexer.cachedToken != nil {
-		token := lexer.cachedToken
-		lexer.cachedToken = nil
+	token, err := lexer.PeekN(1)
+	if err != nil {
+		return nil, err
+	}
+	lexer.cursor++
+	lexer.compact()
+	if lexer.retainTrivia {
+		lexer.consumed = append(lexer.consumed, token)
+	}
+	return token, nil
+}
+
+// Records the source text of a token and the trivia since the last
+// one. Trivia up to the end of a line trails the previous token, and
+// the rest leads this one. Tokens without text, such as INDENT, get
+// no trivia, apart from EOF which leads with all that remains.
+func (lexer *TDOPLexer) attachTrivia(token *Token) {
+	start, end := token.Span.Start.Offset, token.Span.End.Offset
+	if end <= start && token.Symbol != EOF {
+		return
+	}
+	source := lexer.source.Bytes()
+	trivia := string(source[lexer.triviaStart:start])
+	if lexer.lastRaw != nil {
+		if newline := strings.IndexByte(trivia, '\n'); newline >= 0 {
+			lexer.lastRaw.TrailingTrivia = trivia[:newline+1]
+			trivia = trivia[newline+1:]
+		}
+	}
+	token.LeadingTrivia = trivia
+	token.Raw = string(source[start:end])
+	token.rawOffset = start
+	lexer.triviaStart = end
+	lexer.lastRaw = token
+}
+
+// The tokens returned by Next, when retaining trivia
+func (lexer *TDOPLexer) consumedTokens() []*Token {
+	return lexer.consumed
+}
+
+// Reads the next token, inserting newline terminators where the
+// language asks for them
+func (lexer *TDOPLexer) scan() (*Token, error) {
+	if len(lexer.pending) > 0 {
+		token := lexer.pending[0]
+		lexer.pending = lexer.pending[1:]
+		lexer.trackGroupings(token)
 		return token, nil
 	}
+	var token *Token
+	if lexer.newlinePending {
+		lexer.newlinePending = false
+		token = lexer.newlineTerminator(lexer.newlinePosition)
+	}
+	if token == nil {
+		var err error
+		token, err = lexer.scanToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if token.Symbol == EOF {
+		// The final line needs no newline to end its statement
+		if terminator := lexer.newlineTerminator(token.Span.Start); terminator != nil {
+			token = terminator
+			token.Value = ""
+			token.Span.End = token.Span.Start
+		} else if len(lexer.indents) > 0 {
+			// Close indented blocks one at a time, since scanning
+			// again at EOF produces another EOF
+			lexer.indents = lexer.indents[:len(lexer.indents)-1]
+			token = lexer.dedentToken(token.Span.Start)
+		}
+	}
+	if len(lexer.pending) > 0 {
+		lexer.pending = append(lexer.pending, token)
+		token = lexer.pending[0]
+		lexer.pending = lexer.pending[1:]
+	}
+	lexer.trackGroupings(token)
+	return token, nil
+}
+
+// Generates a newline terminator for a newline at the given position
+// if the previous token can end a statement, or returns nil
+func (lexer *TDOPLexer) newlineTerminator(newline Position) *Token {
+	symbol, found := lexer.languageSpec.GetNewlineTerminator()
+	if !found || !lexer.lastEndsStatement {
+		return nil
+	}
+	if len(lexer.groupings) > 0 && lexer.groupings[len(lexer.groupings)-1].suppressesNewlines {
+		return nil
+	}
+	token := lexer.languageSpec.GenerateToken(symbol, "\n", newline.Line, newline.Col)
+	token.Span = Span{
+		File:  lexer.fileName,
+		Start: newline,
+		End:   Position{Line: newline.Line + 1, Col: 1, Offset: newline.Offset + 1},
+	}
+	return token
+}
+
+func (lexer *TDOPLexer) startOfLine() {
+	lexer.atLineStart = true
+	lexer.lineStart = lexer.position
+	lexer.lineIndent.Reset()
+}
+
+func (lexer *TDOPLexer) insideGrouping() bool {
+	depth := len(lexer.groupings)
+	return depth > 0 && lexer.groupings[depth-1].suppressesNewlines
+}
+
+func (lexer *TDOPLexer) currentIndent() string {
+	if len(lexer.indents) == 0 {
+		return ""
+	}
+	return lexer.indents[len(lexer.indents)-1]
+}
+
+// Compares the indentation of the token starting a line with that
+// of the enclosing block, queueing INDENT or DEDENT tokens as needed
+func (lexer *TDOPLexer) indentLine() error {
+	if !lexer.languageSpec.UsesIndentation() || lexer.insideGrouping() {
+		return nil
+	}
+	indent := lexer.lineIndent.String()
+	current := lexer.currentIndent()
+	if strings.Contains(indent, " ") && strings.Contains(indent, "\t") {
+		return lexer.indentationError(MixedIndentation, "indentation mixes tabs and spaces")
+	}
+	switch {
+	case indent == current:
+		return nil
+	case strings.HasPrefix(indent, current):
+		lexer.indents = append(lexer.indents, indent)
+		token := lexer.languageSpec.GenerateToken(Indent, indent, lexer.lineStart.Line, lexer.lineStart.Col)
+		token.Span = Span{
+			File:  lexer.fileName,
+			Start: lexer.lineStart,
+			End:   lexer.tokenStart,
+		}
+		lexer.pending = append(lexer.pending, token)
+		return nil
+	case strings.HasPrefix(current, indent):
+		for len(lexer.currentIndent()) > len(indent) {
+			lexer.indents = lexer.indents[:len(lexer.indents)-1]
+			lexer.pending = append(lexer.pending, lexer.dedentToken(lexer.tokenStart))
+		}
+		if lexer.currentIndent() != indent {
+			return lexer.indentationError(InconsistentIndentation, "unindent does not match any outer indentation level")
+		}
+		return nil
+	default:
+		return lexer.indentationError(MixedIndentation, "indentation is inconsistent in its use of tabs and spaces")
+	}
+}
+
+func (lexer *TDOPLexer) dedentToken(position Position) *Token {
+	token := lexer.languageSpec.GenerateToken(Dedent, "", position.Line, position.Col)
+	token.Span = Span{
+		File:  lexer.fileName,
+		Start: position,
+		End:   position,
+	}
+	return token
+}
+
+// Creates a syntax error covering the indentation of the current line.
+// The token starting the line is kept, so lexing resumes with it.
+func (lexer *TDOPLexer) indentationError(kind SyntaxErrorKind, message string) error {
+	return &SyntaxError{
+		Kind:      kind,
+		Message:   message,
+		File:      lexer.fileName,
+		StartLine: lexer.lineStart.Line,
+		StartCol:  lexer.lineStart.Col,
+		EndLine:   lexer.tokenStart.Line,
+		EndCol:    lexer.tokenStart.Col,
+		Offset:    lexer.lineStart.Offset,
+	}
+}
+
+// An open delimiter awaiting its close
+type openGrouping struct {
+	open               Symbol
+	close              Symbol
+	suppressesNewlines bool
+}
+
+// Tracks the open groupings and blocks, and whether a newline after
+// the token would end a statement
+func (lexer *TDOPLexer) trackGroupings(token *Token) {
+	spec := lexer.languageSpec
+	needsOperand := lexer.lastNeedsOperand
+	lexer.lastEndsStatement = false
+	lexer.lastNeedsOperand = false
+	depth := len(lexer.groupings)
+	if depth > 0 && token.Symbol == lexer.groupings[depth-1].close {
+		lexer.groupings = lexer.groupings[:depth-1]
+		lexer.lastEndsStatement = true
+		return
+	}
+	close, isGrouping := spec.GetGroupingClose(token.Symbol)
+	// Indented blocks are tracked by the indentation stack instead. A
+	// block start that also opens an expression, such as the brace of
+	// a map literal, opens the expression where an operand is expected,
+	// as that is what the parser will read it as.
+	if spec.IsBlockStart(token.Symbol) && spec.GetBlockEnd(token.Symbol) != Dedent && !(isGrouping && needsOperand) {
+		// Statements inside a block still end at newlines, even when
+		// the block is itself inside a grouping
+		lexer.groupings = append(lexer.groupings, openGrouping{
+			open:  token.Symbol,
+			close: spec.GetBlockEnd(token.Symbol),
+		})
+		return
+	}
+	if isGrouping {
+		lexer.groupings = append(lexer.groupings, openGrouping{
+			open:               token.Symbol,
+			close:              close,
+			suppressesNewlines: true,
+		})
+		lexer.lastNeedsOperand = true
+		return
+	}
+	isPostfix := token.Led != nil && token.Arity == 1
+	isValue := token.Nud != nil && token.Led == nil && token.Std == nil && token.Arity == 0
+	// A dedent always directly precedes the token starting its line
+	isBlockEnd := spec.IsAnyBlockEnd(token.Symbol) && token.Symbol != Dedent
+	lexer.lastEndsStatement = isPostfix || isValue || isBlockEnd
+	// Operators, and delimiters such as separators and colons, are
+	// followed by an operand, while statements and keywords are not
+	isDelimiter := token.Nud == nil && token.Led == nil && token.Std == nil && !spec.IsStatementTerminator(token.Symbol)
+	isOperator := token.Led != nil || (token.Nud != nil && token.Arity > 0)
+	lexer.lastNeedsOperand = !lexer.lastEndsStatement && token.Std == nil && (isOperator || isDelimiter)
+}
 
+// Reads the next token from the underlying reader
+func (lexer *TDOPLexer) scanToken() (*Token, error) {
 	char, size, err := lexer.readRune()
 	for size > 0 && err == nil {
 		var token *Token
 		switch lexer.currentState {
-		case unknown:
-			if !unicode.IsSpace(char) {
+		case unknown, whiteSpace:
+			if char == '\n' {
+				lexer.startOfLine()
+				token = lexer.newlineTerminator(lexer.charPosition)
+			} else if unicode.IsSpace(char) {
+				if lexer.atLineStart && (char == ' ' || char == '\t') {
+					lexer.lineIndent.WriteRune(char)
+				}
+			} else {
 				lexer.startOfToken(char)
+				// Comments have no bearing on indentation
+				if lexer.atLineStart && lexer.currentState != comment {
+					lexer.atLineStart = false
+					if err = lexer.indentLine(); err != nil {
+						return nil, err
+					}
+				}
 			}
-		case whiteSpace:
-			if !unicode.IsSpace(char) {
-				lexer.startOfToken(char)
+		case intLiteral, floatLiteral:
+			continues, err := lexer.continueNumber(char)
+			if err != nil {
+				return nil, err
 			}
-		case intLiteral:
-			if unicode.IsDigit(char) {
-				lexer.builder.WriteRune(char)
-			} else if char == '.' {
-				lexer.currentState = floatLiteral
-				lexer.builder.WriteRune(char)
-			} else {
+			if !continues {
 				token, err = lexer.endOfToken()
 				if err != nil {
 					return nil, err
 				}
 				lexer.startOfToken(char)
 			}
-		case floatLiteral:
-			if unicode.IsDigit(char) {
-				lexer.builder.WriteRune(char)
-			} else {
-				token, err = lexer.endOfToken()
-				if err != nil {
+		case stringLiteral:
+			quoteSpec := lexer.currentQuote
+			if quoteSpec.escapes != NoEscapes && char == '\\' {
+				if err = lexer.readEscape(quoteSpec); err != nil {
 					return nil, err
 				}
-				lexer.startOfToken(char)
-			}
-		case stringLiteral:
-			quoteSpecification := lexer.languageSpec.GetQuoteSpec(lexer.currentQuoteStart)
-			if quoteSpecification == nil {
-				// This should never happen,
-				return nil, fmt.Errorf("syntaxerror: unrecognized quote character '%v'", string(lexer.currentQuoteStart))
-			}
-			if char == quoteSpecification.closeQuote {
+			} else if strings.HasPrefix(quoteSpec.closeQuote, string(char)) && lexer.consumeIfNext(quoteSpec.closeQuote[utf8.RuneLen(char):]) {
 				token, err = lexer.endOfToken()
 				if err != nil {
 					return nil, err
 				}
 				lexer.currentState = unknown
-			} else if char == '\n' {
-				return nil, lexer.syntaxError("new line in middle of string literal")
+			} else if char == '\n' && !quoteSpec.multiline {
+				return nil, lexer.tokenSyntaxError(UnterminatedString, "new line in middle of string literal")
+			} else {
+				lexer.builder.WriteRune(char)
+			}
+		case comment:
+			commentSpec := lexer.currentComment
+			if commentSpec.close == "" {
+				// Line comments run up to, but not including, the newline
+				if char == '\n' {
+					lexer.endOfComment()
+					lexer.currentState = whiteSpace
+					lexer.startOfLine()
+					token = lexer.newlineTerminator(lexer.charPosition)
+				} else {
+					lexer.builder.WriteRune(char)
+				}
+			} else if strings.HasPrefix(commentSpec.close, string(char)) && lexer.consumeIfNext(commentSpec.close[utf8.RuneLen(char):]) {
+				lexer.builder.WriteString(commentSpec.close)
+				lexer.commentDepth--
+				if lexer.commentDepth == 0 {
+					lexer.endOfComment()
+					lexer.currentState = unknown
+				}
+			} else if commentSpec.nestable && strings.HasPrefix(commentSpec.open, string(char)) && lexer.consumeIfNext(commentSpec.open[utf8.RuneLen(char):]) {
+				lexer.builder.WriteString(commentSpec.open)
+				lexer.commentDepth++
 			} else {
 				lexer.builder.WriteRune(char)
 			}
@@ -263,25 +1038,23 @@ func (lexer *TDOPLexer) Next() (*Token, error) {
 				lexer.startOfToken(char)
 			}
 		case operator:
-			currentString := lexer.builder.String()
-			stringWithNewChar := currentString + string(char)
-			if lexer.languageSpec.IsDefined(Symbol(stringWithNewChar)) {
+			extended, complete := lexer.continueOperator(char)
+			if extended {
 				lexer.builder.WriteRune(char)
-			} else if lexer.languageSpec.IsDefined(Symbol(currentString)) {
+			} else if complete {
 				token, err = lexer.endOfToken()
 				if err != nil {
 					return nil, err
 				}
 				lexer.startOfToken(char)
 			} else {
-				return nil, fmt.Errorf("syntaxerror: unrecognized operator %v at line %v, col %v", string(char), lexer.line, lexer.col)
+				return nil, lexer.syntaxError(UnrecognizedOperator, "unrecognized operator %v", string(char))
 			}
 		default:
-			return nil, fmt.Errorf("syntaxerror: invalid lexer state state %v at line %v, col %v", lexer.currentState, lexer.line, lexer.col)
+			return nil, lexer.syntaxError(InvalidLexerState, "invalid lexer state state %v", lexer.currentState)
 		}
 
 		if token != nil {
-			fmt.Printf("Returning token %v\n", token.Symbol)
 			return token, nil
 		}
 		char, size, err = lexer.readRune()
@@ -290,9 +1063,16 @@ func (lexer *TDOPLexer) Next() (*Token, error) {
 	if errors.Is(err, io.EOF) {
 		switch lexer.currentState {
 		case stringLiteral:
-			return nil, fmt.Errorf("syntaxerror: unexpected EOF in string literal at line %v, col %v", lexer.line, lexer.col)
+			return nil, lexer.tokenSyntaxError(UnterminatedString, "unexpected EOF in string literal")
 		case eof:
-			return lexer.languageSpec.Eof(lexer.line, lexer.col), nil
+			return lexer.eofToken(), nil
+		case comment:
+			if lexer.currentComment.close != "" {
+				return nil, lexer.tokenSyntaxError(UnterminatedComment, "unexpected EOF in comment")
+			}
+			lexer.endOfComment()
+			lexer.currentState = eof
+			return lexer.eofToken(), nil
 		default:
 			if lexer.builder.Len() > 0 {
 				token, err := lexer.endOfToken()
@@ -303,11 +1083,12 @@ func (lexer *TDOPLexer) Next() (*Token, error) {
 					return token, nil
 				}
 			} else {
-				return lexer.languageSpec.Eof(lexer.line, lexer.col), nil
+				return lexer.eofToken(), nil
 			}
 		}
 	}
-	return nil, fmt.Errorf("syntaxerror: unreadable character %v at line %v, col %v", string(char), lexer.line, lexer.col)
+	// Anything other than EOF is a failure of the underlying reader
+	return nil, err
 }
 
 // func (lexer *TDOPLexer) Next() (*Token, error) {
@@ -1,6 +1,7 @@
 package langkit
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -518,3 +519,400 @@ func areTokensEquivalent(x *Token, y *Token) (bool, string) {
 	}
 	return true, ""
 }
+
+func makeCommentLexer(sourceCode string) *TDOPLexer {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("/", 20)
+	symbolTable.DefineInfix("-", 10)
+	symbolTable.DefineLineComment("//")
+	symbolTable.DefineLineComment("#")
+	symbolTable.DefineBlockComment("/*", "*/", false)
+	symbolTable.DefineBlockComment("{-", "-}", true)
+	return NewLexer(strings.NewReader(sourceCode), symbolTable)
+}
+
+func TestLexerSkipsComments(t *testing.T) {
+	type testCase struct {
+		input    string
+		values   []string
+		comments []string
+	}
+
+	testCases := []testCase{
+		{
+			input:    "A // the rest\nB",
+			values:   []string{"A", "B"},
+			comments: []string{"// the rest"},
+		},
+		{
+			input:    "A/B#trailing",
+			values:   []string{"A", "/", "B"},
+			comments: []string{"#trailing"},
+		},
+		{
+			input:    "A /* one\ntwo */ / B",
+			values:   []string{"A", "/", "B"},
+			comments: []string{"/* one\ntwo */"},
+		},
+		{
+			input:    "A {- outer {- inner -} still outer -} - B",
+			values:   []string{"A", "-", "B"},
+			comments: []string{"{- outer {- inner -} still outer -}"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			lexer := makeCommentLexer(testCase.input)
+			lexer.RetainComments()
+			values := []string{}
+			for {
+				token, err := lexer.Next()
+				if err != nil {
+					t.Fatalf("Unexpected lexing error %v", err)
+				}
+				if token.Symbol == EOF {
+					break
+				}
+				values = append(values, token.Value)
+			}
+			if fmt.Sprint(values) != fmt.Sprint(testCase.values) {
+				t.Fatalf("Expected tokens %v, got %v", testCase.values, values)
+			}
+			comments := []string{}
+			for _, comment := range lexer.Comments() {
+				comments = append(comments, comment.Value)
+			}
+			if fmt.Sprint(comments) != fmt.Sprint(testCase.comments) {
+				t.Fatalf("Expected comments %q, got %q", testCase.comments, comments)
+			}
+		})
+	}
+}
+
+func TestLexerRejectsUnterminatedBlockComment(t *testing.T) {
+	lexer := makeCommentLexer("A /* never closed")
+	lexer.Next()
+	_, err := lexer.Next()
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) || syntaxError.Kind != UnterminatedComment {
+		t.Fatalf("Expected an unterminated comment error, got %v", err)
+	}
+}
+
+func makeQuoteLexer(sourceCode string) *TDOPLexer {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("+", 10)
+	symbolTable.DefineQuotesWithOptions(`"`, `"`, StringLiteral, QuoteOptions{Escapes: CEscapes | UnicodeEscapes})
+	symbolTable.DefineQuotesWithOptions(`"""`, `"""`, StringLiteral, QuoteOptions{Escapes: CEscapes, Multiline: true})
+	symbolTable.DefineQuotesWithOptions("r'", "'", StringLiteral, QuoteOptions{})
+	return NewLexer(strings.NewReader(sourceCode), symbolTable)
+}
+
+func TestLexerStringLiterals(t *testing.T) {
+	type testCase struct {
+		input    string
+		expected []string
+	}
+
+	testCases := []testCase{
+		{input: `"a\"b"`, expected: []string{`a"b`}},
+		{input: `"tab\there\n"`, expected: []string{"tab\there\n"}},
+		{input: `"\\" + "\x41"`, expected: []string{`\`, "+", "A"}},
+		{input: `"\xe9\xFF"`, expected: []string{"éÿ"}},
+		{input: `"\u{48}\u{1F600}"`, expected: []string{"H\U0001F600"}},
+		{input: `r'C:\path\n'`, expected: []string{`C:\path\n`}},
+		{input: "\"\"\"first\n\"second\"\nthird\"\"\"", expected: []string{"first\n\"second\"\nthird"}},
+		{input: `"" + ""`, expected: []string{"", "+", ""}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			lexer := makeQuoteLexer(testCase.input)
+			values := []string{}
+			for {
+				token, err := lexer.Next()
+				if err != nil {
+					t.Fatalf("Unexpected lexing error %v", err)
+				}
+				if token.Symbol == EOF {
+					break
+				}
+				values = append(values, token.Value)
+			}
+			if fmt.Sprintf("%q", values) != fmt.Sprintf("%q", testCase.expected) {
+				t.Fatalf("Expected %q, got %q", testCase.expected, values)
+			}
+		})
+	}
+}
+
+func TestLexerRejectsInvalidStringLiterals(t *testing.T) {
+	type testCase struct {
+		input string
+		kind  SyntaxErrorKind
+	}
+
+	testCases := []testCase{
+		{input: `"\q"`, kind: InvalidEscape},
+		{input: `"\u{110000}"`, kind: InvalidEscape},
+		{input: `"\u48"`, kind: InvalidEscape},
+		{input: `"\xZZ"`, kind: InvalidEscape},
+		{input: "\"one\ntwo\"", kind: UnterminatedString},
+		{input: `"abc\`, kind: UnterminatedString},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			_, err := makeQuoteLexer(testCase.input).Next()
+			var syntaxError *SyntaxError
+			if !errors.As(err, &syntaxError) || syntaxError.Kind != testCase.kind {
+				t.Fatalf("Expected a %v error, got %v", testCase.kind, err)
+			}
+		})
+	}
+}
+
+func makeNumberLexer(sourceCode string) *TDOPLexer {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("+", 10)
+	symbolTable.DefineInfix("-", 10)
+	symbolTable.DefineNumbers(NumberOptions{
+		HexPrefix:      true,
+		OctalPrefix:    true,
+		BinaryPrefix:   true,
+		Exponents:      true,
+		DigitSeparator: '_',
+		Suffixes: map[string]Symbol{
+			"u":   "(UINT)",
+			"f32": "(FLOAT32)",
+		},
+	})
+	return NewLexer(strings.NewReader(sourceCode), symbolTable)
+}
+
+func TestLexerNumericLiterals(t *testing.T) {
+	type testCase struct {
+		input   string
+		symbols []Symbol
+		values  []string
+	}
+
+	testCases := []testCase{
+		{input: "42", symbols: []Symbol{IntLiteral}, values: []string{"42"}},
+		{input: "0x1F", symbols: []Symbol{IntLiteral}, values: []string{"0x1F"}},
+		{input: "0o17+0b101", symbols: []Symbol{IntLiteral, "+", IntLiteral}, values: []string{"0o17", "+", "0b101"}},
+		{input: "1_000_000", symbols: []Symbol{IntLiteral}, values: []string{"1_000_000"}},
+		{input: "1.5", symbols: []Symbol{FloatLiteral}, values: []string{"1.5"}},
+		{input: "1e-9 - 2.5E+3", symbols: []Symbol{FloatLiteral, "-", FloatLiteral}, values: []string{"1e-9", "-", "2.5E+3"}},
+		{input: "10u", symbols: []Symbol{"(UINT)"}, values: []string{"10"}},
+		{input: "1.5f32", symbols: []Symbol{"(FLOAT32)"}, values: []string{"1.5"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			lexer := makeNumberLexer(testCase.input)
+			symbols := []Symbol{}
+			values := []string{}
+			for {
+				token, err := lexer.Next()
+				if err != nil {
+					t.Fatalf("Unexpected lexing error %v", err)
+				}
+				if token.Symbol == EOF {
+					break
+				}
+				symbols = append(symbols, token.Symbol)
+				values = append(values, token.Value)
+			}
+			if fmt.Sprint(symbols) != fmt.Sprint(testCase.symbols) || fmt.Sprint(values) != fmt.Sprint(testCase.values) {
+				t.Fatalf("Expected %v %v, got %v %v", testCase.symbols, testCase.values, symbols, values)
+			}
+		})
+	}
+}
+
+func TestLexerRejectsMalformedNumbers(t *testing.T) {
+	inputs := []string{"1.2.3", "0x", "0b102", "1__0", "10_", "1e", "1e+", "12abc", "3i"}
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			_, err := makeNumberLexer(input).Next()
+			var syntaxError *SyntaxError
+			if !errors.As(err, &syntaxError) || syntaxError.Kind != MalformedNumber {
+				t.Fatalf("Expected a malformed number error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLexerTokenSpans(t *testing.T) {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("=", 10)
+	symbolTable.DefineQuotes('"', '"', StringLiteral)
+	lexer := NewFileLexer(strings.NewReader("Name = \"héllo\"\n  Other = 10"), "spans.toy", symbolTable)
+
+	expected := []Span{
+		{File: "spans.toy", Start: Position{1, 1, 0}, End: Position{1, 5, 4}},
+		{File: "spans.toy", Start: Position{1, 6, 5}, End: Position{1, 7, 6}},
+		{File: "spans.toy", Start: Position{1, 8, 7}, End: Position{1, 15, 15}},
+		{File: "spans.toy", Start: Position{2, 3, 18}, End: Position{2, 8, 23}},
+		{File: "spans.toy", Start: Position{2, 9, 24}, End: Position{2, 10, 25}},
+		{File: "spans.toy", Start: Position{2, 11, 26}, End: Position{2, 13, 28}},
+		{File: "spans.toy", Start: Position{2, 13, 28}, End: Position{2, 13, 28}},
+	}
+	for i, span := range expected {
+		token, err := lexer.Next()
+		if err != nil {
+			t.Fatalf("Unexpected lexing error %v", err)
+		}
+		if token.Span != span {
+			t.Fatalf("Expected token %v (%v) to span %+v, got %+v", i, token.Value, span, token.Span)
+		}
+		if token.Line != span.Start.Line || token.Col != span.Start.Col {
+			t.Fatalf("Expected token %v to start at %v:%v, got %v:%v", i, span.Start.Line, span.Start.Col, token.Line, token.Col)
+		}
+	}
+}
+
+func TestLexerLookahead(t *testing.T) {
+	lexer := makeLexer("A B C D")
+	tracer := &recordingTracer{}
+	lexer.SetTracer(tracer)
+
+	expectValue := func(token *Token, err error, value string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Unexpected lexing error %v", err)
+		}
+		if token.Value != value {
+			t.Fatalf("Expected %q, got %q", value, token.Value)
+		}
+	}
+
+	token, err := lexer.PeekN(3)
+	expectValue(token, err, "C")
+	token, err = lexer.PeekN(5)
+	if err != nil || token.Symbol != EOF {
+		t.Fatalf("Expected EOF beyond the last token, got %v, %v", token, err)
+	}
+	token, err = lexer.Next()
+	expectValue(token, err, "A")
+
+	outer := lexer.Mark()
+	token, err = lexer.Next()
+	expectValue(token, err, "B")
+	inner := lexer.Mark()
+	token, err = lexer.Next()
+	expectValue(token, err, "C")
+	lexer.Reset(inner)
+	token, err = lexer.Peek()
+	expectValue(token, err, "C")
+	lexer.Reset(outer)
+	token, err = lexer.Next()
+	expectValue(token, err, "B")
+
+	mark := lexer.Mark()
+	token, err = lexer.Next()
+	expectValue(token, err, "C")
+	lexer.Release(mark)
+	token, err = lexer.Next()
+	expectValue(token, err, "D")
+
+	// Rewinding must not read or trace any token twice
+	expectedEvents := "[token A token B token C token D token ]"
+	if fmt.Sprint(tracer.events) != expectedEvents {
+		t.Fatalf("Expected events %v, got %v", expectedEvents, tracer.events)
+	}
+	if _, err = lexer.PeekN(0); err == nil {
+		t.Fatalf("Expected an error peeking zero tokens ahead")
+	}
+}
+
+func makeNewlineLexer(sourceCode string) *TDOPLexer {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("=", 5)
+	symbolTable.DefineInfix("+", 10)
+	symbolTable.DefinePostfix("++", 20)
+	symbolTable.DefineParens("(", ")")
+	symbolTable.DefineCall("(", ")", ",")
+	symbolTable.DefineListLiteral("[", "]", ",")
+	symbolTable.DefineBlock("{", "}")
+	symbolTable.DefineMapLiteral("{", "}", ":", ",")
+	symbolTable.DefineStatment("if", func(t *Token, p *TDOPParser) (*Token, error) { return t, nil })
+	symbolTable.DefineQuotes('"', '"', StringLiteral)
+	symbolTable.DefineLineComment("//")
+	symbolTable.DefineBlockComment("/*", "*/", false)
+	symbolTable.DefineNewlineTerminator(";")
+	return NewLexer(strings.NewReader(sourceCode), symbolTable)
+}
+
+func TestLexerInsertsNewlineTerminators(t *testing.T) {
+	testCases := map[string]string{
+		"A\nB":                     "A ; B ;",
+		"A;\nB;\n":                 "A ; B ;",
+		"A +\nB":                   "A + B ;",
+		"A\n+ B":                   "A ; + B ;",
+		"\n\nA\n\n":                "A ;",
+		"A++\nB":                   "A ++ ; B ;",
+		"\"A\" // comment\nB":      "A ; B ;",
+		"f(A,\nB\n)\nC":            "f ( A , B ) ; C ;",
+		"[\nA\n]":                  "[ A ] ;",
+		"if A {\nB\n}\nC":          "if A { B ; } ; C ;",
+		"f(A, if B {\nC\n})":       "f ( A , if B { C ; } ) ;",
+		"{\nA\n}\nB":               "{ A ; } ; B ;",
+		"x = {\nA: 1,\nB: 2\n}":    "x = { A : 1 , B : 2 } ;",
+		"f({\nA: [\n{B: 1}\n]\n})": "f ( { A : [ { B : 1 } ] } ) ;",
+		"A ( B":                    "A ( B",
+		"A // trailing comment":    "A ;",
+		"A /* block */\nB":         "A ; B ;",
+	}
+	for input, expected := range testCases {
+		t.Run(input, func(t *testing.T) {
+			lexer := makeNewlineLexer(input)
+			values := []string{}
+			for {
+				token, err := lexer.Next()
+				if err != nil {
+					t.Fatalf("Unexpected lexing error %v", err)
+				}
+				if token.Symbol == EOF {
+					break
+				}
+				if token.Symbol == ";" {
+					values = append(values, ";")
+				} else {
+					values = append(values, token.Value)
+				}
+			}
+			if strings.Join(values, " ") != expected {
+				t.Fatalf("Expected %v, got %v", expected, strings.Join(values, " "))
+			}
+		})
+	}
+}
+
+func TestLexerEmitsIndentAndDedent(t *testing.T) {
+	symbolTable := NewLanguage()
+	symbolTable.DefineIndentedBlock(":")
+	symbolTable.DefineNewlineTerminator(";")
+	lexer := NewLexer(strings.NewReader("A:\n  B:\n    C\n\n  D\nE:\n  F"), symbolTable)
+	symbols := []string{}
+	for {
+		token, err := lexer.Next()
+		if err != nil {
+			t.Fatalf("Unexpected lexing error %v", err)
+		}
+		if token.Symbol == EOF {
+			break
+		}
+		if token.Symbol == Name {
+			symbols = append(symbols, token.Value)
+		} else {
+			symbols = append(symbols, string(token.Symbol))
+		}
+	}
+	expected := "A : (INDENT) B : (INDENT) C ; (DEDENT) D ; (DEDENT) E : (INDENT) F ; (DEDENT)"
+	if strings.Join(symbols, " ") != expected {
+		t.Fatalf("Expected %v, got %v", expected, strings.Join(symbols, " "))
+	}
+}
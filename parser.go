@@ -1,9 +1,13 @@
 package langkit
 
 import (
+	"errors"
 	"fmt"
 )
 
+// The nesting depth used when a parser does not set MaxDepth
+const DefaultMaxDepth = 1000
+
 func NewParser(lexer Lexer) *TDOPParser {
 	return &TDOPParser{
 		Lexer: lexer,
@@ -12,88 +16,403 @@ func NewParser(lexer Lexer) *TDOPParser {
 
 type TDOPParser struct {
 	Lexer Lexer
+	// When Recover is set, Statements records syntax errors in Errors
+	// and resumes at the next statement boundary instead of returning
+	// the first error
+	Recover bool
+	Errors  []error
+	// The deepest expressions and statements may nest before parsing
+	// fails, guarding against stack exhaustion. Zero means DefaultMaxDepth.
+	MaxDepth int
+	// Observes nud, led and std invocations and parse errors
+	Tracer     Tracer
+	depth      int
+	lastTraced error
+}
+
+func (parser *TDOPParser) enter(token *Token) error {
+	maxDepth := parser.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if parser.depth >= maxDepth {
+		return NewSyntaxError(NestingTooDeep, fmt.Sprintf("nesting exceeds maximum depth of %v", maxDepth), token)
+	}
+	parser.depth++
+	return nil
+}
+
+func (parser *TDOPParser) leave() {
+	parser.depth--
+}
+
+// Reports an error to the tracer unless it is already the most
+// recently reported error, as errors pass through every enclosing call
+func (parser *TDOPParser) traced(node *Token, err error) (*Token, error) {
+	if err == nil {
+		return node, nil
+	}
+	if parser.Tracer != nil && !errors.Is(err, parser.lastTraced) {
+		parser.lastTraced = err
+		parser.Tracer.Error(err)
+	}
+	return nil, err
 }
 
 func (parser *TDOPParser) Block() (*Token, error) {
+	return parser.traced(parser.block())
+}
+
+func (parser *TDOPParser) block() (*Token, error) {
 	token, err := parser.Lexer.Next()
 	if err != nil {
 		return nil, err
 	}
 	if !parser.Lexer.IsBlockStart(token) {
-		return nil, fmt.Errorf("syntaxerror: expected block start, but got %v at line %v, col %v", token.Value, token.Line, token.Col)
+		return nil, NewSyntaxError(ExpectedBlockStart, fmt.Sprintf("expected block start, but got %v", token.describe()), token)
+	}
+	if parser.Tracer != nil {
+		parser.Tracer.StdInvoked(token)
 	}
+	start := parser.consumedCount() - 1
+	node, err := covering(token.Std(token, parser))
+	if err != nil {
+		return nil, err
+	}
+	parser.punctuate(start, node)
+	return node, nil
+}
+
+// The number of tokens consumed so far, when the lexer retains trivia
+func (parser *TDOPParser) consumedCount() int {
+	if log, ok := parser.Lexer.(consumptionLog); ok {
+		return len(log.consumedTokens())
+	}
+	return 0
+}
+
+// Gives a parsed node, as its punctuation, the tokens consumed since
+// start that are not yet part of the tree
+func (parser *TDOPParser) punctuate(start int, node *Token) {
+	log, ok := parser.Lexer.(consumptionLog)
+	if !ok || node == nil {
+		return
+	}
+	node.claimed = true
+	claimSubtree(node)
+	consumed := log.consumedTokens()
+	if start < 0 {
+		start = 0
+	}
+	for _, token := range consumed[start:] {
+		if !token.claimed {
+			token.claimed = true
+			node.Punctuation = append(node.Punctuation, token)
+		}
+	}
+}
+
+// Claims the tokens beneath a node. Claimed nodes were claimed along
+// with everything beneath them, so the walk stops at them.
+func claimSubtree(node *Token) {
+	for _, children := range [][]*Token{node.Children, node.Punctuation} {
+		for _, child := range children {
+			if child != nil && !child.claimed {
+				child.claimed = true
+				claimSubtree(child)
+			}
+		}
+	}
+}
 
-	block, err := token.Std(token, parser)
+// Implemented by lexers that log the tokens they return
+type consumptionLog interface {
+	consumedTokens() []*Token
+}
+
+// Widens a parsed node's span over its children
+func covering(node *Token, err error) (*Token, error) {
 	if err != nil {
 		return nil, err
 	}
-	parser.Lexer.Next()
-	return block, nil
+	if node != nil {
+		node.coverChildren()
+	}
+	return node, nil
 }
 
 func (parser *TDOPParser) Statement() (*Token, error) {
+	return parser.traced(parser.statement())
+}
+
+func (parser *TDOPParser) statement() (*Token, error) {
 	tok, err := parser.Lexer.Peek()
 	if err != nil {
 		return nil, err
 	}
+	if err = parser.enter(tok); err != nil {
+		return nil, err
+	}
+	defer parser.leave()
+	start := parser.consumedCount()
 	if tok.Std != nil {
 		tok, err = parser.Lexer.Next()
 		if err != nil {
 			return nil, err
 		}
-		return tok.Std(tok, parser)
+		if parser.Tracer != nil {
+			parser.Tracer.StdInvoked(tok)
+		}
+		node, err := covering(tok.Std(tok, parser))
+		if err != nil {
+			return nil, err
+		}
+		parser.punctuate(start, node)
+		return node, nil
 	}
 	res, err := parser.Expression(0)
 	if err != nil {
 		return nil, err
 	}
-	terminator, err := parser.Lexer.Next()
-	// TODO - unpanic this
-	if !parser.Lexer.IsStatementTerminator(terminator) {
-
-		panic(fmt.Errorf("syntaxerror: unterminated statement with %v at line %v, col %v", terminator.Value, terminator.Line, terminator.Col))
+	// A block end terminates the final statement of the block, but is
+	// left for the block itself to consume
+	peek, err := parser.Lexer.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if parser.Lexer.IsAnyBlockEnd(peek) {
+		return res, nil
 	}
+	terminator, err := parser.Lexer.Next()
 	if err != nil {
 		return nil, err
 	}
-
+	if !parser.Lexer.IsStatementTerminator(terminator) {
+		return nil, NewSyntaxError(UnterminatedStatement, fmt.Sprintf("unterminated statement with %v", terminator.describe()), terminator)
+	}
+	parser.punctuate(start, res)
 	return res, nil
 }
 
 func (parser *TDOPParser) Statements() ([]*Token, error) {
+	statements, err := parser.statements()
+	_, err = parser.traced(nil, err)
+	return statements, err
+}
+
+func (parser *TDOPParser) statements() ([]*Token, error) {
 	statements := []*Token{}
-	next, err := parser.Lexer.Peek()
+	for {
+		next, err := parser.Lexer.Peek()
+		if err != nil {
+			if err = parser.recoverFrom(err, -1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if next.Symbol == EOF || parser.Lexer.IsAnyBlockEnd(next) {
+			break
+		}
+		// Skip empty statements, such as newlines after a block
+		if parser.Lexer.IsStatementTerminator(next) {
+			if _, err = parser.Lexer.Next(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Recovery may need to return to the start of the statement
+		mark := -1
+		if parser.Recover {
+			mark = parser.Lexer.Mark()
+		}
+		statement, err := parser.Statement()
+		if err != nil {
+			if err = parser.recoverFrom(err, mark); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if mark >= 0 {
+			parser.Lexer.Release(mark)
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// Parses statements up to and including EOF into a Program node whose
+// children are the statements. When the lexer retains trivia, the
+// program's punctuation holds the empty statements and the EOF token
+// with any trailing trivia.
+func (parser *TDOPParser) Program() (*Token, error) {
+	statements, err := parser.Statements()
 	if err != nil {
 		return nil, err
 	}
-	for next.Symbol != EOF && !parser.Lexer.IsAnyBlockEnd(next) {
-		statement, err := parser.Statement()
+	eof, err := parser.Lexer.Next()
+	if err != nil {
+		return parser.traced(nil, err)
+	}
+	if eof.Symbol != EOF {
+		return parser.traced(nil, NewSyntaxError(UnexpectedToken, fmt.Sprintf("unexpected %v", eof.describe()), eof))
+	}
+	program := &Token{
+		Symbol:   Program,
+		Arity:    len(statements),
+		Children: statements,
+		Span:     Span{File: eof.Span.File, Start: eof.Span.Start, End: eof.Span.Start},
+	}
+	if len(statements) > 0 {
+		program.Span = statements[0].Span
+	}
+	program.coverChildren()
+	parser.punctuate(0, program)
+	return program, nil
+}
+
+// Parses statements until EOF, recovering from syntax errors along the
+// way. Returns every statement that parsed along with all errors found.
+func (parser *TDOPParser) StatementsWithRecovery() ([]*Token, []error) {
+	parser.Recover = true
+	statements := []*Token{}
+	for {
+		parsed, err := parser.Statements()
+		statements = append(statements, parsed...)
 		if err != nil {
-			return nil, err
+			parser.Errors = append(parser.Errors, err)
+			break
 		}
-		statements = append(statements, statement)
-		next, err = parser.Lexer.Peek()
+		next, err := parser.Lexer.Next()
 		if err != nil {
-			return nil, err
+			parser.traced(nil, err)
+			parser.Errors = append(parser.Errors, err)
+			break
+		}
+		if next.Symbol == EOF {
+			break
+		}
+		// Statements only stops before EOF at a block end that has no
+		// matching block start, which may already have been reported
+		// by the statement that failed on it
+		if !parser.reported(next) {
+			_, err = parser.traced(nil, NewSyntaxError(UnexpectedToken, fmt.Sprintf("unexpected %v", next.describe()), next))
+			parser.Errors = append(parser.Errors, err)
+		}
+	}
+	return statements, parser.Errors
+}
+
+// Whether the most recent error was reported at the token, counting
+// every EOF token as the same
+func (parser *TDOPParser) reported(token *Token) bool {
+	var syntaxError *SyntaxError
+	if token == nil || len(parser.Errors) == 0 || !errors.As(parser.Errors[len(parser.Errors)-1], &syntaxError) || syntaxError.Token == nil {
+		return false
+	}
+	return syntaxError.Token == token || (token.Symbol == EOF && syntaxError.Token.Symbol == EOF)
+}
+
+// Records a syntax error and skips to the next statement terminator
+// or block end, resolving the mark taken at the start of the failed
+// statement unless it is -1. Returns an error if parsing cannot
+// continue.
+func (parser *TDOPParser) recoverFrom(err error, mark int) error {
+	var syntaxError *SyntaxError
+	if !parser.Recover || !errors.As(err, &syntaxError) {
+		if mark >= 0 {
+			parser.Lexer.Release(mark)
+		}
+		return err
+	}
+	// Errors passed up from a failure already recorded, such as a
+	// block running into EOF after its last statement did, are
+	// reported only once
+	if !parser.reported(syntaxError.Token) {
+		parser.traced(nil, err)
+		parser.Errors = append(parser.Errors, err)
+	}
+	if mark >= 0 {
+		if syntaxError.Token != nil && parser.Lexer.IsAnyBlockEnd(syntaxError.Token) {
+			// A statement that failed on a block end, such as one
+			// missing its last operand, may have consumed it. The block
+			// end is the boundary, so skip the statement up to it and
+			// leave it to close the enclosing block.
+			parser.Lexer.Reset(mark)
+			return parser.skipTo(syntaxError.Token)
+		}
+		parser.Lexer.Release(mark)
+	}
+	// The statement may have failed on its own terminator, in which
+	// case we are already at the boundary
+	if syntaxError.Token != nil && parser.Lexer.IsStatementTerminator(syntaxError.Token) && !parser.Lexer.IsAnyBlockEnd(syntaxError.Token) {
+		return nil
+	}
+	for {
+		next, err := parser.Lexer.Peek()
+		if err != nil {
+			if !errors.As(err, &syntaxError) {
+				return err
+			}
+			parser.traced(nil, err)
+			parser.Errors = append(parser.Errors, err)
+			continue
+		}
+		if next.Symbol == EOF || parser.Lexer.IsAnyBlockEnd(next) {
+			return nil
+		}
+		_, err = parser.Lexer.Next()
+		if err != nil {
+			return err
+		}
+		if parser.Lexer.IsStatementTerminator(next) {
+			return nil
+		}
+	}
+}
+
+// Consumes tokens up to the given token or EOF
+func (parser *TDOPParser) skipTo(token *Token) error {
+	for {
+		next, err := parser.Lexer.Peek()
+		if err != nil {
+			return err
+		}
+		if next == token || next.Symbol == EOF {
+			return nil
+		}
+		if _, err = parser.Lexer.Next(); err != nil {
+			return err
 		}
 	}
-	return statements, nil
 }
 
 func (parser *TDOPParser) Expression(rightBindingPower int) (*Token, error) {
+	return parser.traced(parser.expression(rightBindingPower))
+}
+
+func (parser *TDOPParser) expression(rightBindingPower int) (*Token, error) {
 	var left *Token
 
 	t, err := parser.Lexer.Next()
 	if err != nil {
 		return nil, err
 	}
+	if err = parser.enter(t); err != nil {
+		return nil, err
+	}
+	defer parser.leave()
 	if t.Nud == nil {
-		return nil, fmt.Errorf("%v is not a valid prefix symbol", t.Symbol)
+		return nil, NewSyntaxError(InvalidPrefix, fmt.Sprintf("%v is not a valid prefix symbol", t.Symbol), t)
+	}
+	if parser.Tracer != nil {
+		parser.Tracer.NudInvoked(t, rightBindingPower)
 	}
-	left, err = t.Nud(t, parser)
+	start := parser.consumedCount() - 1
+	left, err = covering(t.Nud(t, parser))
 	if err != nil {
 		return nil, err
 	}
+	parser.punctuate(start, left)
 	for {
 		peek, err := parser.Lexer.Peek()
 		if err != nil {
@@ -107,12 +426,17 @@ func (parser *TDOPParser) Expression(rightBindingPower int) (*Token, error) {
 			return nil, err
 		}
 		if t.Led == nil {
-			return nil, fmt.Errorf("%v is not a valid infix symbol", t.Symbol)
+			return nil, NewSyntaxError(InvalidInfix, fmt.Sprintf("%v is not a valid infix symbol", t.Symbol), t)
+		}
+		if parser.Tracer != nil {
+			parser.Tracer.LedInvoked(t, left, rightBindingPower)
 		}
-		left, err = t.Led(t, parser, left)
+		start := parser.consumedCount() - 1
+		left, err = covering(t.Led(t, parser, left))
 		if err != nil {
 			return nil, err
 		}
+		parser.punctuate(start, left)
 	}
 	return left, nil
 }
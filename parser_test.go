@@ -1,7 +1,9 @@
 package langkit
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -239,3 +241,269 @@ func TestParse(t *testing.T) {
 
 	}
 }
+
+func makeStatementParser(sourceCode string) *TDOPParser {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("AND", 20)
+	symbolTable.DefineInfix("OR", 10)
+	symbolTable.DefineInfix("=", 30)
+	symbolTable.DefineParens("(", ")")
+	symbolTable.DefineQuotes('"', '"', StringLiteral)
+	symbolTable.DefineStatementTerminator(";")
+	symbolTable.DefineBlock("{", "}")
+	return NewParser(NewLexer(strings.NewReader(sourceCode), symbolTable))
+}
+
+func TestStatementsWithRecovery(t *testing.T) {
+	parser := makeStatementParser("A AND ; B = C; { = D; E; } F = \"G\n; I;")
+	statements, errs := parser.StatementsWithRecovery()
+
+	expectedSymbols := []Symbol{"=", Block, Name}
+	if len(statements) != len(expectedSymbols) {
+		t.Fatalf("Expected %v statements, got %v", len(expectedSymbols), len(statements))
+	}
+	for i, statement := range statements {
+		if statement.Symbol != expectedSymbols[i] {
+			t.Fatalf("Expected statement %v to be %v, got %v", i, expectedSymbols[i], statement.Symbol)
+		}
+	}
+	if len(statements[1].Children) != 1 || statements[1].Children[0].Value != "E" {
+		t.Fatalf("Expected block to retain statement E, got %v", statements[1].TreeString(0))
+	}
+
+	expectedKinds := []SyntaxErrorKind{InvalidPrefix, InvalidPrefix, UnterminatedString}
+	if len(errs) != len(expectedKinds) {
+		t.Fatalf("Expected %v errors, got %v: %v", len(expectedKinds), len(errs), errs)
+	}
+	for i, err := range errs {
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) {
+			t.Fatalf("Expected a SyntaxError, got %v", err)
+		}
+		if syntaxError.Kind != expectedKinds[i] {
+			t.Fatalf("Expected error %v to be %v, got %v", i, expectedKinds[i], syntaxError.Kind)
+		}
+	}
+}
+
+func TestStatementsWithRecoveryReportsStrayBlockEnd(t *testing.T) {
+	parser := makeStatementParser("A; } B;")
+	statements, errs := parser.StatementsWithRecovery()
+	if len(statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %v", len(statements))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+}
+
+func TestStatementsWithRecoveryStopsAtBlockEnd(t *testing.T) {
+	testCases := []struct {
+		input    string
+		shapes   string
+		messages []string
+	}{
+		{"{ A AND } B; C;", "[{ B C]", []string{"} is not a valid prefix symbol"}},
+		{"{ { A AND } } B; C;", "[({ {) B C]", []string{"} is not a valid prefix symbol"}},
+		{"{ (A } B;", "[{ B]", []string{"expected ), but got }"}},
+		{"{ A = B; C AND } D;", "[({ (= A B)) D]", []string{"} is not a valid prefix symbol"}},
+		{"A AND } B;", "[B]", []string{"} is not a valid prefix symbol"}},
+		{"{ A", "[]", []string{"unterminated statement with end of input"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			statements, errs := makeStatementParser(testCase.input).StatementsWithRecovery()
+			shapes := []string{}
+			for _, statement := range statements {
+				shapes = append(shapes, treeShape(statement))
+			}
+			if fmt.Sprint(shapes) != testCase.shapes {
+				t.Fatalf("Expected statements %v, got %v", testCase.shapes, shapes)
+			}
+			if len(errs) != len(testCase.messages) {
+				t.Fatalf("Expected errors %v, got %v", testCase.messages, errs)
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), testCase.messages[i]) {
+					t.Fatalf("Expected an error containing %q, got %v", testCase.messages[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestStatementReturnsUnterminatedStatementError(t *testing.T) {
+	parser := makeStatementParser("A = B C;")
+	_, err := parser.Statement()
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) || syntaxError.Kind != UnterminatedStatement {
+		t.Fatalf("Expected an unterminated statement error, got %v", err)
+	}
+}
+
+func TestExpressionEnforcesMaxDepth(t *testing.T) {
+	source := strings.Repeat("(", 100000) + "A" + strings.Repeat(")", 100000)
+	parser := makeStatementParser(source)
+	_, err := parser.Expression(0)
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) || syntaxError.Kind != NestingTooDeep {
+		t.Fatalf("Expected a nesting error, got %v", err)
+	}
+
+	parser = makeStatementParser("((A))")
+	parser.MaxDepth = 2
+	_, err = parser.Expression(0)
+	if !errors.As(err, &syntaxError) || syntaxError.Kind != NestingTooDeep {
+		t.Fatalf("Expected a nesting error, got %v", err)
+	}
+}
+
+func TestParserDoesNotPanicOnMalformedInput(t *testing.T) {
+	inputs := []string{
+		"",
+		";",
+		"}",
+		"{",
+		"{{{",
+		"A = ",
+		"A B C",
+		"(A",
+		"A)",
+		"= = =",
+		"{ A = B",
+		"\"unterminated",
+		"A AND (B OR",
+		strings.Repeat("{", 5000),
+	}
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			makeStatementParser(input).Statements()
+			makeStatementParser(input).StatementsWithRecovery()
+		})
+	}
+}
+
+func TestParsedNodesSpanTheirChildren(t *testing.T) {
+	parser := makeStatementParser("{\n  A = B AND C;\n}")
+	statements, err := parser.Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	block := statements[0]
+	expectedBlock := Span{Start: Position{1, 1, 0}, End: Position{3, 2, 18}}
+	if block.Span != expectedBlock {
+		t.Fatalf("Expected block to span %+v, got %+v", expectedBlock, block.Span)
+	}
+	assignment := block.Children[0]
+	expectedAssignment := Span{Start: Position{2, 3, 4}, End: Position{2, 14, 15}}
+	if assignment.Span != expectedAssignment {
+		t.Fatalf("Expected assignment to span %+v, got %+v", expectedAssignment, assignment.Span)
+	}
+}
+
+func TestParensMustBeClosed(t *testing.T) {
+	parser := makeStatementParser("(A AND B;")
+	_, err := parser.Statement()
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) || syntaxError.Kind != UnexpectedToken || syntaxError.Token.Value != ";" {
+		t.Fatalf("Expected an error at the unclosed parenthesis, got %v", err)
+	}
+
+	parser = makeStatementParser("(A OR B) AND C;")
+	tree, err := parser.Statement()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	if tree.Symbol != "AND" || tree.Children[0].Symbol != "OR" {
+		t.Fatalf("Expected the parenthesized expression to bind first, got\n%v", tree.TreeString(0))
+	}
+}
+
+func TestStatementsEndAtNewlines(t *testing.T) {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfix("AND", 20)
+	symbolTable.DefineInfixRight("=", 10)
+	symbolTable.DefineParens("(", ")")
+	symbolTable.DefineCall("(", ")", ",")
+	symbolTable.DefineBlock("{", "}")
+	symbolTable.DefineNewlineTerminator(";")
+	source := "A = B AND\n  C\n\nD = f(E,\n  F); G\n{\n  H\n  I }\nJ"
+	parser := NewParser(NewLexer(strings.NewReader(source), symbolTable))
+	statements, err := parser.Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	shapes := []string{}
+	for _, statement := range statements {
+		shapes = append(shapes, treeShape(statement))
+	}
+	expected := "[(= A (AND B C)) (= D (( f E F)) G ({ H I) J]"
+	if fmt.Sprint(shapes) != expected {
+		t.Fatalf("Expected %v, got %v", expected, shapes)
+	}
+}
+
+func makeIndentedParser(sourceCode string) *TDOPParser {
+	symbolTable := NewLanguage()
+	symbolTable.DefineInfixRight("=", 10)
+	symbolTable.DefineInfix("+", 20)
+	symbolTable.DefineParens("(", ")")
+	symbolTable.DefineLineComment("#")
+	symbolTable.DefineIndentedBlock(":")
+	symbolTable.DefineNewlineTerminator(";")
+	symbolTable.DefineStatment("if", func(t *Token, p *TDOPParser) (*Token, error) {
+		condition, err := p.Expression(0)
+		if err != nil {
+			return nil, err
+		}
+		block, err := p.Block()
+		if err != nil {
+			return nil, err
+		}
+		t.Children = append(t.Children, condition, block)
+		return t, nil
+	})
+	return NewParser(NewLexer(strings.NewReader(sourceCode), symbolTable))
+}
+
+func TestIndentedBlocks(t *testing.T) {
+	source := "x = 1\nif x:\n    y = 2\n    if y:\n        z = 3\n\n  # comment\n    w = (4 +\n  5)\nv = 6\nif v:\n\tu = 7"
+	statements, err := makeIndentedParser(source).Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+	shapes := []string{}
+	for _, statement := range statements {
+		shapes = append(shapes, treeShape(statement))
+	}
+	expected := "[(= x 1) (if x (: (= y 2) (if y (: (= z 3))) (= w (+ 4 5)))) (= v 6) (if v (: (= u 7)))]"
+	if fmt.Sprint(shapes) != expected {
+		t.Fatalf("Expected %v, got %v", expected, shapes)
+	}
+}
+
+func TestIndentationErrors(t *testing.T) {
+	testCases := map[string]SyntaxErrorKind{
+		"if x:\n    a\n  b": InconsistentIndentation,
+		"if x:\n\ta\n    b": MixedIndentation,
+		"if x:\n \ta":       MixedIndentation,
+		"if x:\na":          ExpectedBlockStart,
+		"a\n  b":            InvalidPrefix,
+	}
+	for input, kind := range testCases {
+		t.Run(input, func(t *testing.T) {
+			_, err := makeIndentedParser(input).Statements()
+			var syntaxError *SyntaxError
+			if !errors.As(err, &syntaxError) || syntaxError.Kind != kind {
+				t.Fatalf("Expected a %v error, got %v", kind, err)
+			}
+		})
+	}
+
+	_, err := makeIndentedParser("if x:\n    a\n  b").Statements()
+	var syntaxError *SyntaxError
+	errors.As(err, &syntaxError)
+	if syntaxError.StartLine != 3 || syntaxError.StartCol != 1 || syntaxError.EndCol != 3 {
+		t.Fatalf("Expected the error to cover the indentation, got %+v", syntaxError)
+	}
+}
@@ -0,0 +1,501 @@
+package langkit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Controls the layout of printed source
+type PrintOptions struct {
+	// One level of indentation inside blocks, four spaces by default
+	Indent string
+	// Ends expression statements. By default the first terminator the
+	// language defines, or none when newlines end statements.
+	Terminator string
+	// Prints statements whose layout the language cannot describe,
+	// such as an if with an else, keyed by the symbol of their node
+	Statements map[Symbol]StatementPrinter
+}
+
+// Prints a statement node, using the printer for its parts
+type StatementPrinter func(printer *Printer, node *Token) (string, error)
+
+// Prints trees as source code of a language, parenthesizing only
+// where the binding powers of the language require it
+type Printer struct {
+	spec    LanguageSpecification
+	options PrintOptions
+	forms   map[Symbol][]Form
+	kinds   map[FormKind][]Form
+	symbols map[Symbol]SymbolInfo
+	depth   int
+}
+
+// An expression printed without enclosing parentheses. Left is the
+// lowest binding power of the operators on its left edge, which a
+// preceding operator binding as tightly would take its left operand
+// from, and right the lowest binding power with which an operator on
+// its right edge parses its right operand, which would absorb a
+// following operator binding more tightly.
+type printed struct {
+	text  string
+	left  int
+	right int
+}
+
+const unbound = math.MaxInt32
+
+// Prints the given statements as source code of the language
+func Print(spec LanguageSpecification, nodes []*Token, options PrintOptions) (string, error) {
+	printer := NewPrinter(spec, options)
+	var builder strings.Builder
+	for _, node := range nodes {
+		if node.Symbol == Program {
+			text, err := printer.statements(node.Children)
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(text)
+			continue
+		}
+		text, err := printer.statements([]*Token{node})
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(text)
+	}
+	return builder.String(), nil
+}
+
+func NewPrinter(spec LanguageSpecification, options PrintOptions) *Printer {
+	printer := &Printer{
+		spec:    spec,
+		options: options,
+		forms:   map[Symbol][]Form{},
+		kinds:   map[FormKind][]Form{},
+		symbols: map[Symbol]SymbolInfo{},
+	}
+	if printer.options.Indent == "" {
+		printer.options.Indent = "    "
+	}
+	for _, form := range spec.Forms() {
+		printer.forms[form.Symbol] = append(printer.forms[form.Symbol], form)
+		printer.kinds[form.Kind] = append(printer.kinds[form.Kind], form)
+	}
+	for _, info := range spec.Symbols() {
+		printer.symbols[info.Symbol] = info
+	}
+	if options.Terminator == "" {
+		if _, found := spec.GetNewlineTerminator(); !found {
+			for _, form := range printer.kinds[TerminatorForm] {
+				if !spec.IsAnyBlockEnd(form.Symbol) {
+					printer.options.Terminator = string(form.Symbol)
+					break
+				}
+			}
+		}
+	}
+	return printer
+}
+
+// Prints an expression node
+func (printer *Printer) Expression(node *Token) (string, error) {
+	expression, err := printer.expression(node)
+	if err != nil {
+		return "", err
+	}
+	return expression.text, nil
+}
+
+// Prints a block node with its statements indented one level
+// further than the statement containing it
+func (printer *Printer) Block(node *Token) (string, error) {
+	form, found := printer.blockForm(node)
+	if !found {
+		return "", fmt.Errorf("printerror: the language defines no block for %v", node.Value)
+	}
+	if form.Kind == BlockForm && len(node.Children) == 0 {
+		return string(form.Symbol) + string(form.Close), nil
+	}
+	printer.depth++
+	statements, err := printer.statements(node.Children)
+	printer.depth--
+	if err != nil {
+		return "", err
+	}
+	if form.Kind == IndentedBlockForm {
+		return string(form.Symbol) + "\n" + statements, nil
+	}
+	return string(form.Symbol) + "\n" + statements + printer.indentation() + string(form.Close), nil
+}
+
+// Prints a statement node without indentation or terminator
+func (printer *Printer) Statement(node *Token) (string, error) {
+	if print, found := printer.options.Statements[node.Symbol]; found {
+		return print(printer, node)
+	}
+	if node.Symbol == Block {
+		return printer.Block(node)
+	}
+	if !printer.isStatement(node) {
+		return printer.Expression(node)
+	}
+	text := node.Value
+	if text == "" {
+		text = string(node.Symbol)
+	}
+	for _, child := range node.Children {
+		var part string
+		var err error
+		if child.Symbol == Block {
+			part, err = printer.Block(child)
+		} else {
+			part, err = printer.Expression(child)
+		}
+		if err != nil {
+			return "", err
+		}
+		text += " " + part
+	}
+	return text, nil
+}
+
+func (printer *Printer) isStatement(node *Token) bool {
+	for _, form := range printer.forms[node.Symbol] {
+		if form.Kind == StatementForm {
+			return true
+		}
+	}
+	return printer.symbols[node.Symbol].HasStd && !printer.spec.IsBlockStart(node.Symbol)
+}
+
+// Prints statements one per line at the current depth, ending any
+// that do not end with a block with the terminator
+func (printer *Printer) statements(nodes []*Token) (string, error) {
+	var builder strings.Builder
+	for _, node := range nodes {
+		text, err := printer.Statement(node)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(printer.indentation())
+		builder.WriteString(text)
+		last := node
+		if len(node.Children) > 0 && printer.isStatement(node) {
+			last = node.Children[len(node.Children)-1]
+		}
+		if last.Symbol != Block {
+			builder.WriteString(printer.options.Terminator)
+		}
+		if !strings.HasSuffix(text, "\n") {
+			builder.WriteString("\n")
+		}
+	}
+	return builder.String(), nil
+}
+
+func (printer *Printer) indentation() string {
+	return strings.Repeat(printer.options.Indent, printer.depth)
+}
+
+func (printer *Printer) blockForm(node *Token) (Form, bool) {
+	var fallback *Form
+	for _, kind := range []FormKind{BlockForm, IndentedBlockForm} {
+		for _, form := range printer.kinds[kind] {
+			if string(form.Symbol) == node.Value {
+				return form, true
+			}
+			if fallback == nil {
+				first := form
+				fallback = &first
+			}
+		}
+	}
+	if fallback == nil {
+		return Form{}, false
+	}
+	return *fallback, true
+}
+
+func (printer *Printer) expression(node *Token) (printed, error) {
+	switch node.Symbol {
+	case FunctionInvocation:
+		return printer.access(CallForm, node)
+	case Index:
+		return printer.access(IndexForm, node)
+	case MemberAccess:
+		return printer.access(MemberAccessForm, node)
+	case ListLiteral:
+		return printer.collection(ListForm, node)
+	case MapLiteral:
+		return printer.collection(MapForm, node)
+	case Block:
+		text, err := printer.Block(node)
+		return printed{text, unbound, unbound}, err
+	}
+
+	forms := printer.forms[node.Symbol]
+	switch len(node.Children) {
+	case 0:
+		return printer.leaf(node)
+	case 1:
+		prefix, isPrefix := findForm(forms, PrefixForm)
+		postfix, isPostfix := findForm(forms, PostfixForm)
+		if isPrefix && isPostfix && precedes(node.Children[0], node) {
+			isPrefix = false
+		}
+		if isPrefix {
+			operand, err := printer.rightOperand(node.Children[0], prefix.BindingPower)
+			if err != nil {
+				return printed{}, err
+			}
+			return printed{printer.join(string(prefix.Symbol), operand.text), unbound, lower(prefix.BindingPower, operand.right)}, nil
+		}
+		if isPostfix {
+			operand, err := printer.leftOperand(node.Children[0], postfix.BindingPower)
+			if err != nil {
+				return printed{}, err
+			}
+			return printed{printer.join(operand.text, string(postfix.Symbol)), lower(postfix.BindingPower, operand.left), unbound}, nil
+		}
+	case 2:
+		if infix, found := findForm(forms, InfixForm); found {
+			rightBindingPower := infix.BindingPower
+			if infix.RightAssociative {
+				rightBindingPower--
+			}
+			left, err := printer.leftOperand(node.Children[0], infix.BindingPower)
+			if err != nil {
+				return printed{}, err
+			}
+			right, err := printer.rightOperand(node.Children[1], rightBindingPower)
+			if err != nil {
+				return printed{}, err
+			}
+			text := left.text + " " + string(infix.Symbol) + " " + right.text
+			return printed{text, lower(infix.BindingPower, left.left), lower(rightBindingPower, right.right)}, nil
+		}
+	case 3:
+		if ternary, found := findForm(forms, TernaryForm); found {
+			condition, err := printer.leftOperand(node.Children[0], ternary.BindingPower)
+			if err != nil {
+				return printed{}, err
+			}
+			consequent, err := printer.rightOperand(node.Children[1], 0)
+			if err != nil {
+				return printed{}, err
+			}
+			alternative, err := printer.rightOperand(node.Children[2], ternary.BindingPower-1)
+			if err != nil {
+				return printed{}, err
+			}
+			text := condition.text + " " + string(ternary.Symbol) + " " + consequent.text + " " + string(ternary.Colon) + " " + alternative.text
+			return printed{text, lower(ternary.BindingPower, condition.left), lower(ternary.BindingPower-1, alternative.right)}, nil
+		}
+	}
+	return printed{}, fmt.Errorf("printerror: the language defines no form of %v with %v operands", node.Symbol, len(node.Children))
+}
+
+func (printer *Printer) leaf(node *Token) (printed, error) {
+	for _, form := range printer.kinds[QuoteForm] {
+		if form.Literal == node.Symbol {
+			text, err := quote(form, node.Value)
+			return printed{text, unbound, unbound}, err
+		}
+	}
+	text := node.Value
+	if text == "" {
+		text = string(node.Symbol)
+	}
+	return printed{text, unbound, unbound}, nil
+}
+
+// Prints calls, indexing and member access, whose target is their
+// left operand
+func (printer *Printer) access(kind FormKind, node *Token) (printed, error) {
+	forms := printer.kinds[kind]
+	if len(forms) == 0 || len(node.Children) == 0 {
+		return printed{}, fmt.Errorf("printerror: the language defines no form of %v", node.Symbol)
+	}
+	form := forms[0]
+	target, err := printer.leftOperand(node.Children[0], form.BindingPower)
+	if err != nil {
+		return printed{}, err
+	}
+	var text string
+	switch kind {
+	case MemberAccessForm:
+		if len(node.Children) != 2 {
+			return printed{}, fmt.Errorf("printerror: member access needs a target and a member")
+		}
+		// A number would read the dot as its decimal point
+		if first, _ := utf8.DecodeRuneInString(target.text); unicode.IsDigit(first) {
+			target.text += " "
+		}
+		text = target.text + string(form.Symbol) + node.Children[1].Value
+	case CallForm:
+		arguments, err := printer.items(node.Children[1:], form.Separator)
+		if err != nil {
+			return printed{}, err
+		}
+		text = target.text + string(form.Symbol) + arguments + string(form.Close)
+	default:
+		arguments, err := printer.items(node.Children[1:], "")
+		if err != nil {
+			return printed{}, err
+		}
+		text = target.text + string(form.Symbol) + arguments + string(form.Close)
+	}
+	return printed{text, lower(form.BindingPower, target.left), unbound}, nil
+}
+
+func (printer *Printer) collection(kind FormKind, node *Token) (printed, error) {
+	forms := printer.kinds[kind]
+	if len(forms) == 0 {
+		return printed{}, fmt.Errorf("printerror: the language defines no form of %v", node.Symbol)
+	}
+	form := forms[0]
+	items := node.Children
+	if kind == MapForm {
+		items = []*Token{}
+		for _, entry := range node.Children {
+			if len(entry.Children) != 2 {
+				return printed{}, fmt.Errorf("printerror: map entries need a key and a value")
+			}
+			items = append(items, entry.Children...)
+		}
+	}
+	texts := []string{}
+	for i, item := range items {
+		text, err := printer.Expression(item)
+		if err != nil {
+			return printed{}, err
+		}
+		if kind == MapForm && i%2 == 1 {
+			texts[len(texts)-1] += string(form.Colon) + " " + text
+			continue
+		}
+		texts = append(texts, text)
+	}
+	text := string(form.Symbol) + strings.Join(texts, string(form.Separator)+" ") + string(form.Close)
+	return printed{text, unbound, unbound}, nil
+}
+
+func (printer *Printer) items(nodes []*Token, separator Symbol) (string, error) {
+	texts := []string{}
+	for _, node := range nodes {
+		text, err := printer.Expression(node)
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, string(separator)+" "), nil
+}
+
+// Prints an operand that an operator with the given binding power
+// follows, parenthesized if its right edge would absorb the operator
+func (printer *Printer) leftOperand(node *Token, bindingPower int) (printed, error) {
+	operand, err := printer.expression(node)
+	if err != nil || operand.right >= bindingPower {
+		return operand, err
+	}
+	return printer.parenthesized(operand)
+}
+
+// Prints an operand parsed with the given right binding power,
+// parenthesized if an operator on its left edge would end it early
+func (printer *Printer) rightOperand(node *Token, rightBindingPower int) (printed, error) {
+	operand, err := printer.expression(node)
+	if err != nil || operand.left > rightBindingPower {
+		return operand, err
+	}
+	return printer.parenthesized(operand)
+}
+
+func (printer *Printer) parenthesized(expression printed) (printed, error) {
+	forms := printer.kinds[ParensForm]
+	if len(forms) == 0 {
+		return printed{}, fmt.Errorf("printerror: the language defines no parentheses to group %v", expression.text)
+	}
+	return printed{string(forms[0].Symbol) + expression.text + string(forms[0].Close), unbound, unbound}, nil
+}
+
+// Joins an operator and its operand, separated by a space where the
+// lexer would otherwise read them as one token
+func (printer *Printer) join(left string, right string) string {
+	last, _ := utf8.DecodeLastRuneInString(left)
+	first, _ := utf8.DecodeRuneInString(right)
+	identifiers := printer.spec.IsIdentifierCharacter(last) && printer.spec.IsIdentifierCharacter(first)
+	if identifiers || printer.spec.IsDefined(Symbol(string(last)+string(first))) {
+		return left + " " + right
+	}
+	return left + right
+}
+
+func lower(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func findForm(forms []Form, kind FormKind) (Form, bool) {
+	for _, form := range forms {
+		if form.Kind == kind {
+			return form, true
+		}
+	}
+	return Form{}, false
+}
+
+// Checks whether a node starts before an operator token in the source
+func precedes(node *Token, operator *Token) bool {
+	if !node.Span.IsValid() {
+		return false
+	}
+	start := node.Span.Start
+	return start.Line < operator.Line || (start.Line == operator.Line && start.Col < operator.Col)
+}
+
+// Quotes a literal value, escaping what the quotes require
+func quote(form Form, value string) (string, error) {
+	close := string(form.Close)
+	if form.Escapes == NoEscapes {
+		if strings.Contains(value, close) {
+			return "", fmt.Errorf("printerror: cannot quote %q with %v without escapes", value, close)
+		}
+		return string(form.Symbol) + value + close, nil
+	}
+	var builder strings.Builder
+	builder.WriteString(string(form.Symbol))
+	for i, char := range value {
+		escaped := ""
+		if form.Escapes&CEscapes != 0 {
+			switch {
+			case char == '\\':
+				escaped = `\\`
+			case char == '\n':
+				escaped = `\n`
+			case char == '\t':
+				escaped = `\t`
+			case char == '\r':
+				escaped = `\r`
+			case strings.HasPrefix(value[i:], close):
+				escaped = `\` + string(char)
+			}
+		} else if char == '\\' || char == '\n' || char == '\r' || strings.HasPrefix(value[i:], close) {
+			escaped = fmt.Sprintf(`\u{%X}`, char)
+		}
+		if escaped == "" {
+			builder.WriteRune(char)
+		} else {
+			builder.WriteString(escaped)
+		}
+	}
+	builder.WriteString(close)
+	return builder.String(), nil
+}
@@ -0,0 +1,112 @@
+package langkit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintUsesMinimalParentheses(t *testing.T) {
+	testCases := map[string]string{
+		"a = b = c;":                 "a = b = c;",
+		"(a = b) = c;":               "(a = b) = c;",
+		"(a + b) + c;":               "a + b + c;",
+		"a + (b + c);":               "a + (b + c);",
+		"a - (b - c) - d;":           "a - (b - c) - d;",
+		"-(a + b);":                  "-(a + b);",
+		"(-a)!;":                     "(-a)!;",
+		"-(a!);":                     "-a!;",
+		"- -a;":                      "--a;",
+		"(a ? b : c) ? d : e;":       "(a ? b : c) ? d : e;",
+		"a ? (b = c) : (d ? e : f);": "a ? b = c : d ? e : f;",
+		"f((a + b), [c, (d)]).e;":    "f(a + b, [c, d]).e;",
+		"(a + b).c(-d)!;":            "(a + b).c(-d)!;",
+		"(\"s\");":                   "\"s\";",
+		"1 .y;":                      "1 .y;",
+		"(1.5).y;":                   "1.5 .y;",
+	}
+	spec := makeDocumentedLanguage()
+	for input, expected := range testCases {
+		statements, err := NewParser(NewLexer(strings.NewReader(input), spec)).Statements()
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", input, err)
+		}
+		actual, err := Print(spec, statements, PrintOptions{})
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", input, err)
+		}
+		if actual != expected+"\n" {
+			t.Fatalf("Expected %q to print as %q, got %q", input, expected, actual)
+		}
+		reparsed, err := NewParser(NewLexer(strings.NewReader(actual), spec)).Statements()
+		if err != nil || treeShape(reparsed[0]) != treeShape(statements[0]) {
+			t.Fatalf("Expected %q to parse as %v, got %v", actual, treeShape(statements[0]), err)
+		}
+	}
+}
+
+func TestPrintLaysOutBlocks(t *testing.T) {
+	spec := makeDocumentedLanguage()
+	source := "{ return; a; { } b ; { return; } } c;"
+	program, err := NewParser(NewLexer(strings.NewReader(source), spec)).Program()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := "{\n\treturn;\n\ta;\n\t{}\n\tb;\n\t{\n\t\treturn;\n\t}\n}\nc;\n"
+	actual, err := Print(spec, []*Token{program}, PrintOptions{Indent: "\t"})
+	if err != nil || actual != expected {
+		t.Fatalf("Expected %q, got %q, %v", expected, actual, err)
+	}
+}
+
+func TestPrintIndentedBlocks(t *testing.T) {
+	ifPrinter := func(printer *Printer, node *Token) (string, error) {
+		condition, err := printer.Expression(node.Children[0])
+		if err != nil {
+			return "", err
+		}
+		block, err := printer.Block(node.Children[1])
+		return "if " + condition + block, err
+	}
+	source := "x = 1\nif x:\n  y = (2 + 3)\n  if y:\n        z = 3\nw = 4"
+	statements, err := makeIndentedParser(source).Statements()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	spec := makeIndentedParser("").Lexer.(*TDOPLexer).languageSpec
+	expected := "x = 1\nif x:\n    y = 2 + 3\n    if y:\n        z = 3\nw = 4\n"
+	actual, err := Print(spec, statements, PrintOptions{Statements: map[Symbol]StatementPrinter{"if": ifPrinter}})
+	if err != nil || actual != expected {
+		t.Fatalf("Expected %q, got %q, %v", expected, actual, err)
+	}
+}
+
+func TestPrintQuotesLiterals(t *testing.T) {
+	spec := NewLanguage()
+	spec.DefineQuotesWithOptions(`"`, `"`, StringLiteral, QuoteOptions{Escapes: CEscapes})
+	spec.DefineQuotesWithOptions("'", "'", charLiteral, QuoteOptions{Escapes: UnicodeEscapes})
+	spec.DefineQuotes('`', '`', templateLiteral)
+	spec.DefineInfix("+", 10)
+	spec.DefineStatementTerminator(";")
+
+	node := &Token{Symbol: "+", Children: []*Token{
+		{Symbol: "+", Children: []*Token{
+			{Symbol: StringLiteral, Value: "a\"b\\\n"},
+			{Symbol: charLiteral, Value: "'\\"},
+		}},
+		{Symbol: templateLiteral, Value: "raw \\n"},
+	}}
+	expected := "\"a\\\"b\\\\\\n\" + '\\u{27}\\u{5C}' + `raw \\n`;\n"
+	actual, err := Print(spec, []*Token{node}, PrintOptions{})
+	if err != nil || actual != expected {
+		t.Fatalf("Expected %q, got %q, %v", expected, actual, err)
+	}
+	reparsed, err := NewParser(NewLexer(strings.NewReader(actual), spec)).Statement()
+	if err != nil || reparsed.Children[0].Children[0].Value != "a\"b\\\n" || reparsed.Children[0].Children[1].Value != "'\\" {
+		t.Fatalf("Expected the literals to survive printing, got %v", err)
+	}
+
+	node.Children[1].Value = "a`b"
+	if _, err := Print(spec, []*Token{node}, PrintOptions{}); err == nil || !strings.HasPrefix(err.Error(), "printerror: ") {
+		t.Fatalf("Expected an error quoting a backquote without escapes, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package langkit
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Classifies a syntax error so that tools can react to
+// particular failures without inspecting the message
+type SyntaxErrorKind string
+
+const (
+	UnexpectedCharacter     SyntaxErrorKind = "unexpectedcharacter"
+	UnrecognizedOperator    SyntaxErrorKind = "unrecognizedoperator"
+	UnterminatedString      SyntaxErrorKind = "unterminatedstring"
+	UnterminatedComment     SyntaxErrorKind = "unterminatedcomment"
+	InvalidEscape           SyntaxErrorKind = "invalidescape"
+	MalformedNumber         SyntaxErrorKind = "malformednumber"
+	InvalidLexerState       SyntaxErrorKind = "invalidlexerstate"
+	UnexpectedToken         SyntaxErrorKind = "unexpectedtoken"
+	ExpectedBlockStart      SyntaxErrorKind = "expectedblockstart"
+	UnterminatedStatement   SyntaxErrorKind = "unterminatedstatement"
+	InvalidPrefix           SyntaxErrorKind = "invalidprefix"
+	InvalidInfix            SyntaxErrorKind = "invalidinfix"
+	NestingTooDeep          SyntaxErrorKind = "nestingtoodeep"
+	InconsistentIndentation SyntaxErrorKind = "inconsistentindentation"
+	MixedIndentation        SyntaxErrorKind = "mixedindentation"
+)
+
+// A syntax error raised by the lexer or the parser. Lines and
+// columns are 1-based, the end column is exclusive and Offset is
+// the byte offset of the start of the error, or -1 if unknown.
+type SyntaxError struct {
+	Kind      SyntaxErrorKind
+	Message   string
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	Offset    int
+	Token     *Token
+}
+
+// Creates a syntax error covering the given token
+func NewSyntaxError(kind SyntaxErrorKind, message string, token *Token) *SyntaxError {
+	if token.Span.IsValid() {
+		return &SyntaxError{
+			Kind:      kind,
+			Message:   message,
+			File:      token.Span.File,
+			StartLine: token.Span.Start.Line,
+			StartCol:  token.Span.Start.Col,
+			EndLine:   token.Span.End.Line,
+			EndCol:    token.Span.End.Col,
+			Offset:    token.Span.Start.Offset,
+			Token:     token,
+		}
+	}
+	width := utf8.RuneCountInString(token.Value)
+	if width == 0 {
+		width = 1
+	}
+	return &SyntaxError{
+		Kind:      kind,
+		Message:   message,
+		StartLine: token.Line,
+		StartCol:  token.Col,
+		EndLine:   token.Line,
+		EndCol:    token.Col + width,
+		Offset:    -1,
+		Token:     token,
+	}
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("syntaxerror: %v at line %v, col %v", err.Message, err.StartLine, err.StartCol)
+}
+
+// Renders the error followed by the offending source line with
+// the erroneous range underlined by carets
+func (err *SyntaxError) Render(source string) string {
+	var builder strings.Builder
+	builder.WriteString(err.Error())
+	builder.WriteString("\n")
+	lines := strings.Split(source, "\n")
+	if err.StartLine < 1 || err.StartLine > len(lines) {
+		return builder.String()
+	}
+	line := strings.TrimRight(lines[err.StartLine-1], "\r")
+	builder.WriteString(line)
+	builder.WriteString("\n")
+
+	lineLength := utf8.RuneCountInString(line)
+	start := err.StartCol
+	if start < 1 {
+		start = 1
+	}
+	end := err.EndCol
+	if err.EndLine != err.StartLine || end > lineLength+1 {
+		end = lineLength + 1
+	}
+	if end <= start {
+		end = start + 1
+	}
+	col := 1
+	for _, char := range line {
+		if col >= start {
+			break
+		}
+		// Preserve tabs so the carets line up with the source
+		if char == '\t' {
+			builder.WriteRune('\t')
+		} else {
+			builder.WriteRune(' ')
+		}
+		col++
+	}
+	for ; col < start; col++ {
+		builder.WriteRune(' ')
+	}
+	builder.WriteString(strings.Repeat("^", end-start))
+	builder.WriteString("\n")
+	return builder.String()
+}
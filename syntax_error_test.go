@@ -0,0 +1,63 @@
+package langkit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLexerReturnsSyntaxError(t *testing.T) {
+	lexer := makeLexer("A = \"Hello")
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = lexer.Next()
+	}
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) {
+		t.Fatalf("Expected a SyntaxError, got %v", err)
+	}
+	if syntaxError.Kind != UnterminatedString {
+		t.Fatalf("Expected kind %v, got %v", UnterminatedString, syntaxError.Kind)
+	}
+	if syntaxError.StartLine != 1 || syntaxError.StartCol != 5 || syntaxError.Offset != 4 {
+		t.Fatalf("Unexpected location %v:%v offset %v", syntaxError.StartLine, syntaxError.StartCol, syntaxError.Offset)
+	}
+}
+
+func TestParserReturnsSyntaxError(t *testing.T) {
+	lexer := makeLexer("A AND = B")
+	parser := NewParser(lexer)
+	_, err := parser.Expression(0)
+	var syntaxError *SyntaxError
+	if !errors.As(err, &syntaxError) {
+		t.Fatalf("Expected a SyntaxError, got %v", err)
+	}
+	if syntaxError.Kind != InvalidPrefix {
+		t.Fatalf("Expected kind %v, got %v", InvalidPrefix, syntaxError.Kind)
+	}
+	if syntaxError.Token == nil || syntaxError.Token.Symbol != "=" {
+		t.Fatalf("Expected offending token =, got %v", syntaxError.Token)
+	}
+}
+
+func TestSyntaxErrorRender(t *testing.T) {
+	source := "A = B\nC AND\tDD ~ E\n"
+	err := &SyntaxError{
+		Kind:      UnrecognizedOperator,
+		Message:   "unrecognized operator ~",
+		StartLine: 2,
+		StartCol:  10,
+		EndLine:   2,
+		EndCol:    11,
+		Offset:    15,
+	}
+	expected := strings.Join([]string{
+		"syntaxerror: unrecognized operator ~ at line 2, col 10",
+		"C AND\tDD ~ E",
+		"     \t   ^",
+		"",
+	}, "\n")
+	if rendered := err.Render(source); rendered != expected {
+		t.Fatalf("Expected\n%q\ngot\n%q", expected, rendered)
+	}
+}
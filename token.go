@@ -19,12 +19,58 @@ const (
 	ElseIf             Symbol = "(ELSEIF)"
 	FunctionDefinition Symbol = "(FUNCTIONDEFINITION)"
 	FunctionParameters Symbol = "(FUNCTIONPARAMETERS)"
+	Comment            Symbol = "(COMMENT)"
+	Index              Symbol = "(INDEX)"
+	MemberAccess       Symbol = "(MEMBERACCESS)"
+	ListLiteral        Symbol = "(LIST)"
+	MapLiteral         Symbol = "(MAP)"
+	MapEntry           Symbol = "(MAPENTRY)"
+	Indent             Symbol = "(INDENT)"
+	Dedent             Symbol = "(DEDENT)"
+	Program            Symbol = "(PROGRAM)"
 )
 
 type NudFunction func(right *Token, parser *TDOPParser) (*Token, error)
 type LedFunction func(right *Token, parser *TDOPParser, left *Token) (*Token, error)
 type StdFunction func(*Token, *TDOPParser) (*Token, error)
 
+// A location in source text. Line and Col are 1-based, with Col
+// counting characters, while Offset is a 0-based byte offset.
+type Position struct {
+	Line   int
+	Col    int
+	Offset int
+}
+
+// A range of source text, excluding the End position
+type Span struct {
+	File  string
+	Start Position
+	End   Position
+}
+
+// Spans of tokens created outside the lexer are left empty
+func (span Span) IsValid() bool {
+	return span.Start.Line > 0
+}
+
+// Returns the smallest span covering both spans
+func (span Span) Union(other Span) Span {
+	if !other.IsValid() {
+		return span
+	}
+	if !span.IsValid() {
+		return other
+	}
+	if other.Start.Offset < span.Start.Offset {
+		span.Start = other.Start
+	}
+	if other.End.Offset > span.End.Offset {
+		span.End = other.End
+	}
+	return span
+}
+
 type Token struct {
 	Symbol       Symbol
 	Value        string
@@ -32,10 +78,26 @@ type Token struct {
 	BindingPower int
 	Line         int
 	Col          int
-	Children     []*Token
-	Nud          NudFunction
-	Led          LedFunction
-	Std          StdFunction
+	// Covers the token's own text and, once parsed, its children
+	Span     Span
+	Children []*Token
+	Nud      NudFunction
+	Led      LedFunction
+	Std      StdFunction
+	// Set only by lexers retaining trivia: the exact source text of
+	// the token, the whitespace and comments before it, and those
+	// after it up to the end of its line
+	Raw            string
+	LeadingTrivia  string
+	TrailingTrivia string
+	// Tokens consumed while parsing the node that are not part of the
+	// tree otherwise, such as closing parentheses and separators
+	Punctuation []*Token
+	// The offset of Raw in the source, which unlike the span is not
+	// widened over the children
+	rawOffset int
+	// Whether the token already belongs to a node of the tree
+	claimed bool
 }
 
 func (token *Token) TreeString(indentLevel int) string {
@@ -50,3 +112,25 @@ func (token *Token) TreeString(indentLevel int) string {
 	}
 	return builder.String()
 }
+
+// Names the token in error messages
+func (token *Token) describe() string {
+	if token.Symbol == EOF {
+		return "end of input"
+	}
+	return token.Value
+}
+
+// Widens the token's span to cover its children, first filling in
+// the spans of children created outside the lexer
+func (token *Token) coverChildren() {
+	for _, child := range token.Children {
+		if child == nil {
+			continue
+		}
+		if !child.Span.IsValid() {
+			child.coverChildren()
+		}
+		token.Span = token.Span.Union(child.Span)
+	}
+}
@@ -18,5 +18,8 @@ func Exception(
 	exceptionType ExceptionType,
 	message string,
 	token *langkit.Token) langkit.Exception {
+	if exceptionType == SyntaxError {
+		return langkit.NewSyntaxError(langkit.UnexpectedToken, message, token)
+	}
 	return fmt.Errorf("%v: %v at %v:%v", exceptionType, message, token.Line, token.Col)
 }
@@ -6,10 +6,38 @@ import (
 	"github.com/nicholasbailey/langkit"
 )
 
+// Peeks at the token after an if statement's block, skipping a newline
+// terminator if else follows it, so that else may start its own line
+func peekPastNewlineBeforeElse(parser *langkit.TDOPParser) (*langkit.Token, error) {
+	next, err := parser.Lexer.Peek()
+	if err != nil || next.Symbol != ";" || next.Value != "\n" {
+		return next, err
+	}
+	following, err := parser.Lexer.PeekN(2)
+	if err != nil || following.Value != "else" {
+		return next, err
+	}
+	if _, err = parser.Lexer.Next(); err != nil {
+		return nil, err
+	}
+	return following, nil
+}
+
 func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 	spec := langkit.NewLanguage()
-	spec.DefineQuotes('"', '"', langkit.StringLiteral)
-	spec.DefineQuotes('\'', '\'', langkit.StringLiteral)
+	escapes := langkit.CEscapes | langkit.UnicodeEscapes
+	spec.DefineQuotesWithOptions(`"`, `"`, langkit.StringLiteral, langkit.QuoteOptions{Escapes: escapes})
+	spec.DefineQuotesWithOptions(`'`, `'`, langkit.StringLiteral, langkit.QuoteOptions{Escapes: escapes})
+	spec.DefineQuotesWithOptions(`"""`, `"""`, langkit.StringLiteral, langkit.QuoteOptions{Escapes: escapes, Multiline: true})
+	spec.DefineNumbers(langkit.NumberOptions{
+		HexPrefix:      true,
+		OctalPrefix:    true,
+		BinaryPrefix:   true,
+		Exponents:      true,
+		DigitSeparator: '_',
+	})
+	spec.DefineLineComment("//")
+	spec.DefineBlockComment("/*", "*/", false)
 	spec.DefineParens("(", ")")
 	spec.DefinePrefix("!", 80)
 	spec.DefineInfix("&&", 30)
@@ -27,7 +55,7 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 	spec.DefineInfix("/", 70)
 	spec.DefineInfix("%", 70)
 	spec.DefineStatementTerminator(";")
-	spec.DefineEmpty(",")
+	spec.DefineNewlineTerminator(";")
 	spec.DefineBlock("{", "}")
 	spec.DefineEmpty("else")
 	ifStd := func(token *langkit.Token, parser *langkit.TDOPParser) (*langkit.Token, error) {
@@ -36,23 +64,12 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 			return nil, err
 		}
 		token.Children = append(token.Children, expression)
-		peek, err := parser.Lexer.Peek()
-		if err != nil {
-			return nil, err
-		}
-		// TODO - remove this Hack because expression parser is wonky
-		if peek.Symbol == ")" {
-			_, err = parser.Lexer.Next()
-			if err != nil {
-				return nil, err
-			}
-		}
 		block, err := parser.Block()
 		if err != nil {
 			return nil, err
 		}
 		token.Children = append(token.Children, block)
-		next, err := parser.Lexer.Peek()
+		next, err := peekPastNewlineBeforeElse(parser)
 		if err != nil {
 			return nil, err
 		}
@@ -87,23 +104,12 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 			return nil, err
 		}
 		token.Children = append(token.Children, expression)
-		peek, err := parser.Lexer.Peek()
-		if err != nil {
-			return nil, err
-		}
-		// Hack because expression parser is wonky
-		if peek.Symbol == ")" {
-			_, err = parser.Lexer.Next()
-			if err != nil {
-				return nil, err
-			}
-		}
 		block, err := parser.Block()
 		if err != nil {
 			return nil, err
 		}
 		token.Children = append(token.Children, block)
-		next, err := parser.Lexer.Peek()
+		next, err := peekPastNewlineBeforeElse(parser)
 		if err != nil {
 			return nil, err
 		}
@@ -138,17 +144,6 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 		if err != nil {
 			return nil, err
 		}
-		peek, err := parser.Lexer.Peek()
-		if err != nil {
-			return nil, err
-		}
-		// TODO - remove this Hack because expression parser is wonky
-		if peek.Symbol == ")" {
-			_, err = parser.Lexer.Next()
-			if err != nil {
-				return nil, err
-			}
-		}
 		token.Children = append(token.Children, expression)
 		block, err := parser.Block()
 		if err != nil {
@@ -160,55 +155,9 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 
 	spec.DefineStatment("while", whileStd)
 
-	openParensLed := func(right *langkit.Token, parser *langkit.TDOPParser, left *langkit.Token) (*langkit.Token, error) {
-		if left.Symbol != langkit.Name && left.Symbol != langkit.Symbol("(") {
-			return nil, fmt.Errorf("syntaxerror: unexpected ( at line %v, col %v", right.Line, right.Col)
-		}
-		right.Children = append(right.Children, left)
-		t, err := parser.Lexer.Peek()
-		if err != nil {
-			return nil, err
-		}
-		if t.Symbol != ")" {
-			for {
-				expressionResult, err := parser.Expression(0)
-				if err != nil {
-					return nil, err
-				}
-				right.Children = append(right.Children, expressionResult)
-				right, err := parser.Lexer.Peek()
-				if err != nil {
-					return nil, err
-				}
-				if right.Symbol != "," {
-					break
-				}
-				_, err = parser.Lexer.Next()
-				if err != nil {
-					return nil, err
-				}
-			}
-			close, err := parser.Lexer.Next()
-			if err != nil {
-				return nil, err
-			}
-			if close.Symbol != ")" {
-				return nil, fmt.Errorf("syntaxerror: unterminated parentheses with symbol %v at line %v, col %v", close.Value, close.Line, close.Col)
-			}
-		} else {
-			_, err = parser.Lexer.Next()
-			if err != nil {
-				return nil, err
-			}
-		}
-		right.Symbol = langkit.FunctionInvocation
-		return right, nil
-	}
-
-	spec.Define("(", 90, 0, nil, openParensLed, nil)
+	spec.DefineCall("(", ")", ",")
 
 	defStd := func(token *langkit.Token, parser *langkit.TDOPParser) (*langkit.Token, error) {
-		fmt.Printf("Parsing function definition\n")
 		token.Symbol = langkit.FunctionDefinition
 		functionName, err := parser.Lexer.Next()
 		if err != nil {
@@ -234,7 +183,7 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 		if next.Symbol != ")" {
 			for {
 				if next.Symbol != langkit.Name {
-					return nil, err
+					return nil, Exception(SyntaxError, fmt.Sprintf("expected parameter name, got %v", next.Value), next)
 				}
 				parameters = append(parameters, next)
 				further, err := parser.Lexer.Peek()
@@ -254,7 +203,7 @@ func BuildToyscriptLanguageSpec() langkit.LanguageSpecification {
 				return nil, err
 			}
 			if close.Symbol != ")" {
-				return nil, fmt.Errorf("syntaxerror: unterminated parentheses with symbol %v at line %v, col %v", close.Value, close.Line, close.Col)
+				return nil, Exception(SyntaxError, fmt.Sprintf("unterminated parentheses with symbol %v", close.Value), close)
 			}
 		} else {
 			_, err = parser.Lexer.Next()
@@ -3,19 +3,23 @@ package engine
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/nicholasbailey/langkit"
 )
 
 func TestToyscriptLexer(t *testing.T) {
-	f, _ := os.Open("./test_scripts/test_simple.toy")
+	f, _ := os.Open("../../test_scripts/test_simple.toy")
 	spec := BuildToyscriptLanguageSpec()
 	lexer := langkit.NewLexer(f, spec)
-	token, _ := lexer.Next()
-	for token.Symbol != langkit.EOF {
+	token, err := lexer.Next()
+	for err == nil && token.Symbol != langkit.EOF {
 		fmt.Printf("%v", token.TreeString(0))
-		token, _ = lexer.Next()
+		token, err = lexer.Next()
+	}
+	if err != nil {
+		t.Fatalf("%v", err)
 	}
 }
 
@@ -35,8 +39,25 @@ func TestToyscriptParser(t *testing.T) {
 }
 
 func TestToyscriptInterpreter(t *testing.T) {
-	f, _ := os.Open("./test_scripts/test_simple.toy")
+	f, _ := os.Open("../../test_scripts/test_simple.toy")
 	engine := BuildToyscriptEngine()
 	val, err := engine.Execute(f)
 	fmt.Printf("%v err: %v", val, err)
 }
+
+func TestToyscriptElseOnItsOwnLine(t *testing.T) {
+	sources := []string{
+		"if (a) {\n  b\n} else if (c) {\n  d\n} else {\n  e\n}\nf",
+		"if (a) {\n  b\n}\nelse if (c) {\n  d\n}\nelse {\n  e\n}\nf",
+	}
+	for _, source := range sources {
+		parser := langkit.NewParser(langkit.NewLexer(strings.NewReader(source), BuildToyscriptLanguageSpec()))
+		statements, err := parser.Statements()
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", source, err)
+		}
+		if len(statements) != 2 || len(statements[0].Children) != 3 || statements[0].Children[2].Symbol != langkit.ElseIf || len(statements[0].Children[2].Children) != 3 {
+			t.Fatalf("%q: expected an if, else if and else followed by f, got %v", source, statements)
+		}
+	}
+}
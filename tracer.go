@@ -0,0 +1,53 @@
+package langkit
+
+import (
+	"fmt"
+	"io"
+)
+
+// Observes the lexer and parser as they work, for logging or
+// debugging a grammar. Lexers and parsers without a tracer stay silent.
+type Tracer interface {
+	// Called for each token the lexer reads from its source
+	TokenEmitted(token *Token)
+	// Called before a token's nud runs, with the binding power the
+	// enclosing expression is parsed at
+	NudInvoked(token *Token, rightBindingPower int)
+	// Called before a token's led runs on the left operand
+	LedInvoked(token *Token, left *Token, rightBindingPower int)
+	// Called before a token's std runs
+	StdInvoked(token *Token)
+	// Called once for each error reported by the parser
+	Error(err error)
+}
+
+// Creates a tracer that writes one line per event to writer
+func NewLogTracer(writer io.Writer) Tracer {
+	return &logTracer{
+		writer: writer,
+	}
+}
+
+type logTracer struct {
+	writer io.Writer
+}
+
+func (tracer *logTracer) TokenEmitted(token *Token) {
+	fmt.Fprintf(tracer.writer, "token %v %q at line %v, col %v\n", token.Symbol, token.Value, token.Line, token.Col)
+}
+
+func (tracer *logTracer) NudInvoked(token *Token, rightBindingPower int) {
+	fmt.Fprintf(tracer.writer, "nud %v rbp %v at line %v, col %v\n", token.Symbol, rightBindingPower, token.Line, token.Col)
+}
+
+func (tracer *logTracer) LedInvoked(token *Token, left *Token, rightBindingPower int) {
+	fmt.Fprintf(tracer.writer, "led %v bp %v rbp %v left %v at line %v, col %v\n", token.Symbol, token.BindingPower, rightBindingPower, left.Symbol, token.Line, token.Col)
+}
+
+func (tracer *logTracer) StdInvoked(token *Token) {
+	fmt.Fprintf(tracer.writer, "std %v at line %v, col %v\n", token.Symbol, token.Line, token.Col)
+}
+
+func (tracer *logTracer) Error(err error) {
+	fmt.Fprintf(tracer.writer, "error %v\n", err)
+}
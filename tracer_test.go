@@ -0,0 +1,93 @@
+package langkit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingTracer struct {
+	events []string
+}
+
+func (tracer *recordingTracer) TokenEmitted(token *Token) {
+	tracer.events = append(tracer.events, fmt.Sprintf("token %v", token.Value))
+}
+
+func (tracer *recordingTracer) NudInvoked(token *Token, rightBindingPower int) {
+	tracer.events = append(tracer.events, fmt.Sprintf("nud %v %v", token.Value, rightBindingPower))
+}
+
+func (tracer *recordingTracer) LedInvoked(token *Token, left *Token, rightBindingPower int) {
+	tracer.events = append(tracer.events, fmt.Sprintf("led %v %v %v", token.Value, left.Value, rightBindingPower))
+}
+
+func (tracer *recordingTracer) StdInvoked(token *Token) {
+	tracer.events = append(tracer.events, fmt.Sprintf("std %v", token.Value))
+}
+
+func (tracer *recordingTracer) Error(err error) {
+	tracer.events = append(tracer.events, "error")
+}
+
+func TestTracerObservesLexerAndParser(t *testing.T) {
+	tracer := &recordingTracer{}
+	parser := makeStatementParser("{ A = B AND C; }")
+	parser.Lexer.(*TDOPLexer).SetTracer(tracer)
+	parser.Tracer = tracer
+	_, err := parser.Statements()
+	if err != nil {
+		t.Fatalf("Unexpected parsing error %v", err)
+	}
+
+	expected := []string{
+		"token {",
+		"std {",
+		"token A",
+		"nud A 0",
+		"token =",
+		"led = A 0",
+		"token B",
+		"nud B 30",
+		"token AND",
+		"led AND = 0",
+		"token C",
+		"nud C 20",
+		"token ;",
+		"token }",
+		"token ",
+	}
+	if strings.Join(tracer.events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected events\n%v\ngot\n%v", strings.Join(expected, "\n"), strings.Join(tracer.events, "\n"))
+	}
+}
+
+func TestTracerReportsEachErrorOnce(t *testing.T) {
+	tracer := &recordingTracer{}
+	parser := makeStatementParser("{ { A = ; } }")
+	parser.Tracer = tracer
+	_, err := parser.Statements()
+	if err == nil {
+		t.Fatalf("Expected a parsing error")
+	}
+	errors := 0
+	for _, event := range tracer.events {
+		if event == "error" {
+			errors++
+		}
+	}
+	if errors != 1 {
+		t.Fatalf("Expected one error event, got %v", errors)
+	}
+}
+
+func TestLogTracer(t *testing.T) {
+	var builder strings.Builder
+	parser := makeStatementParser("A;")
+	parser.Lexer.(*TDOPLexer).SetTracer(NewLogTracer(&builder))
+	parser.Tracer = NewLogTracer(&builder)
+	parser.Statements()
+	if !strings.Contains(builder.String(), "token (NAME) \"A\" at line 1, col 1\n") || !strings.Contains(builder.String(), "nud (NAME) rbp 0") {
+		t.Fatalf("Unexpected trace output %q", builder.String())
+	}
+}
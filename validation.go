@@ -0,0 +1,181 @@
+package langkit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Classifies a problem with a language specification
+type SpecificationErrorKind string
+
+const (
+	ConflictingDefinition     SpecificationErrorKind = "conflictingdefinition"
+	OperatorSplitsIdentifiers SpecificationErrorKind = "operatorsplitsidentifiers"
+	UnreachableOperator       SpecificationErrorKind = "unreachableoperator"
+	BlockEndIsValue           SpecificationErrorKind = "blockendisvalue"
+	QuoteHidesOperator        SpecificationErrorKind = "quotehidesoperator"
+	CommentHidesOperator      SpecificationErrorKind = "commenthidesoperator"
+)
+
+// A problem found in a language specification
+type SpecificationError struct {
+	Kind    SpecificationErrorKind
+	Symbol  Symbol
+	Message string
+}
+
+func newSpecificationError(kind SpecificationErrorKind, symbol Symbol, format string, args ...interface{}) *SpecificationError {
+	return &SpecificationError{
+		Kind:    kind,
+		Symbol:  symbol,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (err *SpecificationError) Error() string {
+	return fmt.Sprintf("specificationerror: %v", err.Message)
+}
+
+// Returns an error if defining the given functions for symbol would
+// replace functions it already has
+func definitionConflict(symbol Symbol, existing *Token, nud NudFunction, led LedFunction, std StdFunction) error {
+	switch {
+	case nud != nil && existing.Nud != nil:
+		return newSpecificationError(ConflictingDefinition, symbol, "%v already has a nud", symbol)
+	case led != nil && existing.Led != nil:
+		return newSpecificationError(ConflictingDefinition, symbol, "%v already has a led", symbol)
+	case std != nil && existing.Std != nil:
+		return newSpecificationError(ConflictingDefinition, symbol, "%v already has a std", symbol)
+	}
+	return nil
+}
+
+func (spec *languageSpecificationImpl) SetStrict(strict bool) {
+	spec.strict = strict
+}
+
+// Checks whether form was declared before, in which case defining it
+// again changes nothing
+func (spec *languageSpecificationImpl) repeatsForm(form *Form) bool {
+	if form == nil {
+		return false
+	}
+	for _, declared := range spec.forms {
+		if declared == *form {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *languageSpecificationImpl) Validate() []error {
+	errors := append([]error{}, spec.conflicts...)
+	symbols := spec.lexicalSymbols()
+
+	for _, symbol := range symbols {
+		text := string(symbol)
+		first, size := utf8.DecodeRuneInString(text)
+		if size == len(text) {
+			if unicode.IsLetter(first) || first == '_' {
+				errors = append(errors, newSpecificationError(OperatorSplitsIdentifiers, symbol, "operator %v splits any identifier containing it", symbol))
+			}
+			continue
+		}
+		if spec.isKeyword(text) {
+			continue
+		}
+		if spec.IsIdentifierStartChararacter(first) {
+			errors = append(errors, newSpecificationError(UnreachableOperator, symbol, "operator %v is read as the start of an identifier", symbol))
+			continue
+		}
+		for end := size; end < len(text); {
+			if !spec.IsDefined(Symbol(text[:end])) {
+				errors = append(errors, newSpecificationError(UnreachableOperator, symbol, "operator %v cannot be read because %v is not defined", symbol, text[:end]))
+				break
+			}
+			_, next := utf8.DecodeRuneInString(text[end:])
+			end += next
+		}
+	}
+
+	starts := make([]Symbol, 0, len(spec.blockDelimiters))
+	for start := range spec.blockDelimiters {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	for _, start := range starts {
+		end := spec.blockDelimiters[start]
+		if token, found := spec.symbols[end]; found && token.Nud != nil {
+			errors = append(errors, newSpecificationError(BlockEndIsValue, end, "%v ends blocks opened by %v but is also a value", end, start))
+		}
+	}
+
+	for _, form := range spec.forms {
+		if form.Symbol == "" {
+			continue
+		}
+		var kind SpecificationErrorKind
+		switch form.Kind {
+		case QuoteForm:
+			kind = QuoteHidesOperator
+		case LineCommentForm, BlockCommentForm:
+			kind = CommentHidesOperator
+		default:
+			continue
+		}
+		for _, symbol := range symbols {
+			if strings.HasPrefix(string(symbol), string(form.Symbol)) {
+				errors = append(errors, newSpecificationError(kind, symbol, "operator %v is read as the %v %v", symbol, form.Kind, form.Symbol))
+			}
+		}
+	}
+	return errors
+}
+
+// Lists the defined symbols that are read from source text, leaving
+// out the symbols of literals and of indentation
+func (spec *languageSpecificationImpl) lexicalSymbols() []Symbol {
+	literals := map[Symbol]bool{
+		Name:         true,
+		IntLiteral:   true,
+		FloatLiteral: true,
+		Indent:       true,
+		Dedent:       true,
+	}
+	for _, quoteSpecs := range spec.quoteDefinitions {
+		for _, quoteSpec := range quoteSpecs {
+			literals[quoteSpec.literalType] = true
+		}
+	}
+	for _, symbol := range spec.numberOptions.Suffixes {
+		literals[symbol] = true
+	}
+	symbols := []Symbol{}
+	for symbol := range spec.symbols {
+		if !literals[symbol] {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i] < symbols[j]
+	})
+	return symbols
+}
+
+// Checks whether text is read as a single identifier
+func (spec *languageSpecificationImpl) isKeyword(text string) bool {
+	for i, char := range text {
+		if i == 0 && !spec.IsIdentifierStartChararacter(char) {
+			return false
+		}
+		if !spec.IsIdentifierCharacter(char) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package langkit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func specificationErrorKinds(t *testing.T, errs []error) []SpecificationErrorKind {
+	kinds := []SpecificationErrorKind{}
+	for _, err := range errs {
+		var specErr *SpecificationError
+		if !errors.As(err, &specErr) {
+			t.Fatalf("Expected a SpecificationError, got %v", err)
+		}
+		kinds = append(kinds, specErr.Kind)
+	}
+	return kinds
+}
+
+func TestStrictModeRejectsConflicts(t *testing.T) {
+	spec := NewLanguage()
+	spec.SetStrict(true)
+	spec.DefineQuotes('"', '"', StringLiteral)
+	spec.DefineQuotes('\'', '\'', StringLiteral)
+	spec.DefineInfix("-", 60)
+	spec.DefinePrefix("-", 80)
+	spec.DefineInfix("-", 60)
+	spec.DefineInfix("-", 70)
+	spec.DefineStatementTerminator("-")
+	spec.DefineQuotes('\'', '\'', Symbol("(CHAR)"))
+
+	errs := spec.Validate()
+	kinds := specificationErrorKinds(t, errs)
+	if len(kinds) != 3 || kinds[0] != ConflictingDefinition || kinds[1] != ConflictingDefinition || kinds[2] != ConflictingDefinition {
+		t.Fatalf("Expected three conflicts, got %v", errs)
+	}
+	if errs[0].Error() != "specificationerror: - already has a led" {
+		t.Fatalf("Unexpected error %v", errs[0])
+	}
+
+	parser := NewParser(NewLexer(strings.NewReader("1 - 2 - 3"), spec))
+	tree, err := parser.Expression(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shape := treeShape(tree); shape != "(- (- 1 2) 3)" {
+		t.Fatalf("Expected the first definition to be kept, got %v", shape)
+	}
+	for _, info := range spec.Symbols() {
+		if info.Symbol == "-" && info.BindingPower != 80 {
+			t.Fatalf("Expected - to keep binding power 80, got %v", info.BindingPower)
+		}
+	}
+}
+
+func TestStrictModeDoesNotRecordRejectedForms(t *testing.T) {
+	spec := NewLanguage()
+	spec.SetStrict(true)
+	spec.DefineInfix("-", 60)
+	spec.DefineQuotes('"', '"', StringLiteral)
+	forms := fmt.Sprint(spec.Forms())
+	symbols := fmt.Sprint(spec.Symbols())
+
+	for i := 0; i < 2; i++ {
+		spec.DefineInfix("-", 70)
+		spec.DefineInfixRight("-", 70)
+		spec.DefinePostfix("-", 90)
+		spec.DefineQuotesWithOptions("'", "'", StringLiteral, QuoteOptions{Nud: func(t *Token, p *TDOPParser) (*Token, error) {
+			return t, nil
+		}})
+	}
+	if len(spec.Validate()) != 8 {
+		t.Fatalf("Expected every rejected definition to be reported, got %v", spec.Validate())
+	}
+	if actual := fmt.Sprint(spec.Forms()); actual != forms {
+		t.Fatalf("Expected forms %v, got %v", forms, actual)
+	}
+	if actual := fmt.Sprint(spec.Symbols()); actual != symbols {
+		t.Fatalf("Expected symbols %v, got %v", symbols, actual)
+	}
+	if len(spec.GetQuoteSpecs('\'')) != 0 {
+		t.Fatalf("Expected the rejected quote to be left out")
+	}
+}
+
+func TestLenientModeMergesConflicts(t *testing.T) {
+	spec := NewLanguage()
+	spec.DefineInfix("-", 60)
+	spec.DefineInfix("-", 70)
+	if errs := spec.Validate(); len(errs) != 0 {
+		t.Fatalf("Expected no errors outside strict mode, got %v", errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		define func(spec LanguageSpecification)
+		kind   SpecificationErrorKind
+		symbol Symbol
+	}{
+		{"letter operator", func(spec LanguageSpecification) { spec.DefineInfix("x", 70) }, OperatorSplitsIdentifiers, "x"},
+		{"identifier-like operator", func(spec LanguageSpecification) {
+			spec.DefineInfix("+", 60)
+			spec.DefineInfix("a+", 70)
+		}, UnreachableOperator, "a+"},
+		{"missing prefix", func(spec LanguageSpecification) {
+			spec.DefineInfix("=", 10)
+			spec.DefineInfix("=>>", 10)
+		}, UnreachableOperator, "=>>"},
+		{"value block end", func(spec LanguageSpecification) {
+			spec.DefineValue("end")
+			spec.DefineBlock("do", "end")
+		}, BlockEndIsValue, "end"},
+		{"quote", func(spec LanguageSpecification) {
+			spec.DefinePostfix("'", 90)
+			spec.DefineQuotes('\'', '\'', StringLiteral)
+		}, QuoteHidesOperator, "'"},
+		{"comment", func(spec LanguageSpecification) {
+			spec.DefineInfix("#", 50)
+			spec.DefineLineComment("#")
+		}, CommentHidesOperator, "#"},
+	}
+	for _, test := range tests {
+		spec := NewLanguage()
+		spec.SetStrict(true)
+		test.define(spec)
+		errs := spec.Validate()
+		if len(errs) != 1 {
+			t.Fatalf("%v: expected one error, got %v", test.name, errs)
+		}
+		specErr := errs[0].(*SpecificationError)
+		if specErr.Kind != test.kind || specErr.Symbol != test.symbol {
+			t.Fatalf("%v: expected %v for %v, got %v", test.name, test.kind, test.symbol, specErr)
+		}
+	}
+}
+
+func TestValidLanguagesValidate(t *testing.T) {
+	spec := NewLanguage()
+	spec.SetStrict(true)
+	spec.DefineQuotes('"', '"', StringLiteral)
+	spec.DefineLineComment("//")
+	spec.DefineInfix("/", 70)
+	spec.DefineInfix("=", 10)
+	spec.DefineInfix("==", 50)
+	spec.DefineInfix("-", 60)
+	spec.DefinePrefix("-", 80)
+	spec.DefineValue("true")
+	spec.DefineParens("(", ")")
+	spec.DefineCall("(", ")", ",")
+	spec.DefineListLiteral("[", "]", ",")
+	spec.DefineIndex("[", "]")
+	spec.DefineMapLiteral("{", "}", ":", ",")
+	spec.DefineBlock("{", "}")
+	spec.DefineStatementTerminator(";")
+	spec.DefineNewlineTerminator(";")
+	spec.DefineStatment("return", func(t *Token, p *TDOPParser) (*Token, error) { return t, nil })
+	if errs := spec.Validate(); len(errs) != 0 {
+		t.Fatalf("Expected no errors, got %v", errs)
+	}
+}